@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/jbvmio/modules/storage"
+	"github.com/spf13/viper"
 
 	"go.uber.org/zap"
 )
@@ -31,7 +32,7 @@ type ApplicationContext struct {
 	ConfigurationValid bool
 
 	// This is the channel over which any module should send storage requests for storage of offsets and group
-	// information, or to fetch the same information. It is serviced by the storage Module.
+	// information, or to fetch the same information. It is serviced by the storage modules, through the StorageRouter.
 	StorageChannel chan *storage.Request
 
 	// Modules contains all loaded Modules
@@ -41,7 +42,8 @@ type ApplicationContext struct {
 	WG sync.WaitGroup
 
 	loadedModules    map[string]Module
-	storageModule    *StorageModule
+	storageModules   []StorageModule
+	storageRouter    *StorageRouter
 	quitChannel      chan struct{}
 	running          sync.WaitGroup
 	hasStorageModule bool
@@ -120,28 +122,34 @@ func (app *ApplicationContext) ConfigureModules() {
 	for module := range app.loadedModules {
 		app.loadedModules[module].Configure()
 		if isStorageModule(app.loadedModules[module]) {
-			if !app.hasStorageModule {
-				app.Logger.Info("Loading Main Storage Module",
-					zap.String(app.loadedModules[module].ModuleDetails()),
-				)
-				// Set up main channel for storage
-				app.StorageChannel = make(chan *storage.Request)
-				app.hasStorageModule = true
-				storage := app.loadedModules[module].(StorageModule)
-				app.storageModule = &storage
-				go app.StartStorage(app.storageModule)
-			} else {
-				sm := *app.storageModule
-				_, name := sm.ModuleDetails()
-				app.loadedModules[module].ModuleLogger().Error("Main Storage Module Already Loaded",
-					zap.String("loaded storage", name),
-				)
-				app.Logger.Error("Multiple Storage Modules Loaded")
-				app.ConfigurationValid = false
-				return
-			}
+			app.Logger.Info("Loading Storage Module",
+				zap.String(app.loadedModules[module].ModuleDetails()),
+			)
+			app.storageModules = append(app.storageModules, app.loadedModules[module].(StorageModule))
 		}
 	}
+
+	// Route storage requests across all of the storage modules, by the Index routes in storage.router.routes and
+	// the storage.router.default module, or otherwise by hashing the Index name.
+	if len(app.storageModules) > 0 {
+		router, err := NewStorageRouter(app.storageModules,
+			viper.GetStringMapString("storage.router.routes"),
+			viper.GetString("storage.router.default"),
+		)
+		if err != nil {
+			app.Logger.Error("Invalid Storage Router Configuration",
+				zap.Error(err),
+			)
+			app.ConfigurationValid = false
+			return
+		}
+		router.logRoutes(app.Logger)
+		// Set up main channel for storage
+		app.StorageChannel = make(chan *storage.Request)
+		app.hasStorageModule = true
+		app.storageRouter = router
+		go app.StartStorageRouter(router)
+	}
 	app.ConfigurationValid = true
 }
 
@@ -196,31 +204,44 @@ func StopLoadedModules(modules map[string]Module) {
 	}
 }
 
-// StartStorage here.
-// Possibly add channelRouter here - take(modules []*StorageModule) and Route to all.
+// StartStorage forwards the requests sent over the StorageChannel to a single storage module, which must have been
+// configured. It is kept for callers which start the storage module themselves; StartStorageRouter forwards to
+// several storage modules.
 func (app *ApplicationContext) StartStorage(module *StorageModule) {
+	router, err := NewStorageRouter([]StorageModule{*module}, nil, "")
+	if err != nil {
+		app.Logger.Error("Invalid Storage Module",
+			zap.Error(err),
+		)
+		return
+	}
+	app.StartStorageRouter(router)
+}
+
+// StartStorageRouter forwards the requests sent over the StorageChannel to the storage modules using the
+// StorageRouter. Requests for an Index are forwarded as is, so long-lived requests such as TypeWatch keep their Reply
+// and Done channels and are answered directly by the storage module.
+func (app *ApplicationContext) StartStorageRouter(router *StorageRouter) {
 	app.running.Add(1)
 	defer app.running.Done()
 
-	// We only support 1 module right now, so only send to that module
-	var channel chan *storage.Request
-	for _, module := range app.Modules {
-		channel = module.(StorageModule).GetCommunicationChannel()
-	}
-
 	for {
 		select {
 		case request := <-app.StorageChannel:
-			// Yes, this forwarder is silly. However, in the future multiple storage modules could be implemented
-			// concurrently. However, that will require implementing a router that properly handles sets and
-			// fetches and makes sure only 1 module responds to fetches
-			channel <- request
+			if !router.Forward(request, app.quitChannel) {
+				return
+			}
 		case <-app.quitChannel:
 			return
 		}
 	}
 }
 
+// StorageRouter returns the StorageRouter used to route storage requests, or nil if no storage module is loaded.
+func (app *ApplicationContext) StorageRouter() *StorageRouter {
+	return app.storageRouter
+}
+
 func (app *ApplicationContext) initModules() {
 	var tmp []Module
 	already := make(map[string]bool, len(app.Modules))
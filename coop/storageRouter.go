@@ -0,0 +1,225 @@
+package coop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// snapshotSeparator separates the module name from the snapshot ID in the snapshot IDs returned by the router.
+const snapshotSeparator = "/"
+
+// ReplyTimeout is how long the router waits for a merged reply to be received before dropping it.
+const ReplyTimeout = storage.RejectTimeout
+
+// StorageRouter routes storage Requests across one or more storage modules by Index name. An Index is assigned to a
+// module by a static route if one is set, then to the default module if one is set, and is otherwise hashed across
+// all modules. Every Request for an Index is forwarded to the same single module, so only one module answers each
+// fetch.
+//
+// Requests which are not for a single Index are handled by the router. TypeFetchIndexes requests are broadcast to
+// every module and the results merged into a single reply. TypeBatch requests with operations for several modules are
+// split, and the results merged in the order of the operations. Snapshot IDs are prefixed with the name of the module
+// which created them, so later requests for the snapshot are forwarded to that module.
+type StorageRouter struct {
+	names    []string
+	channels map[string]chan *storage.Request
+	routes   map[string]string
+	fallback string
+}
+
+// NewStorageRouter returns a StorageRouter for the given storage modules, which must have been configured. Routes
+// maps Index names to module names, and fallback, if not empty, is the module name for all other Indexes.
+func NewStorageRouter(modules []StorageModule, routes map[string]string, fallback string) (*StorageRouter, error) {
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no storage modules")
+	}
+	router := &StorageRouter{
+		channels: make(map[string]chan *storage.Request, len(modules)),
+		routes:   make(map[string]string, len(routes)),
+		fallback: fallback,
+	}
+	for _, module := range modules {
+		_, name := module.ModuleDetails()
+		if strings.Contains(name, snapshotSeparator) {
+			return nil, fmt.Errorf("storage module name %q must not contain %q", name, snapshotSeparator)
+		}
+		router.names = append(router.names, name)
+		router.channels[name] = module.GetCommunicationChannel()
+	}
+	sort.Strings(router.names)
+	for index, name := range routes {
+		if _, ok := router.channels[name]; !ok {
+			return nil, fmt.Errorf("unknown storage module %q for index %q", name, index)
+		}
+		router.routes[index] = name
+	}
+	if _, ok := router.channels[fallback]; fallback != "" && !ok {
+		return nil, fmt.Errorf("unknown default storage module %q", fallback)
+	}
+	return router, nil
+}
+
+// Modules returns the names of the storage modules in sorted order.
+func (r *StorageRouter) Modules() []string {
+	return r.names
+}
+
+// Module returns the name of the storage module for the Index.
+func (r *StorageRouter) Module(index string) string {
+	if name, ok := r.routes[index]; ok {
+		return name
+	}
+	if r.fallback != "" {
+		return r.fallback
+	}
+	return r.names[xxhash.ChecksumString64(index)%uint64(len(r.names))]
+}
+
+// Forward routes the Request to the storage modules. It returns false if the quit channel was closed before the
+// Request could be forwarded. Replies merged by the router are sent from separate goroutines, so Forward never waits
+// for a storage module to reply, and are dropped if not received within ReplyTimeout or before the context of the
+// Request is done.
+func (r *StorageRouter) Forward(request *storage.Request, quit chan struct{}) bool {
+	switch {
+	case request.Snapshot != "":
+		name, id, ok := strings.Cut(request.Snapshot, snapshotSeparator)
+		if _, known := r.channels[name]; !ok || !known {
+			storage.Reject(request, storage.Errorf(storage.CodeUnknownSnapshot, "%v", request.Snapshot))
+			return true
+		}
+		forward := *request
+		forward.Snapshot = id
+		return r.send(name, &forward, quit)
+	case request.RequestType == storage.TypeSnapshot:
+		return r.snapshot(request, quit)
+	case request.RequestType == storage.TypeFetchIndexes:
+		return r.fetchIndexes(request, quit)
+	case request.RequestType == storage.TypeBatch:
+		return r.batch(request, quit)
+	}
+	return r.send(r.Module(request.Index), request, quit)
+}
+
+func (r *StorageRouter) send(name string, request *storage.Request, quit chan struct{}) bool {
+	select {
+	case r.channels[name] <- request:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// snapshot forwards a TypeSnapshot request and prefixes the snapshot ID in the reply with the module name. A snapshot
+// of every Index can only be taken with a single storage module.
+func (r *StorageRouter) snapshot(request *storage.Request, quit chan struct{}) bool {
+	name := r.names[0]
+	if request.Index != "" {
+		name = r.Module(request.Index)
+	} else if len(r.names) > 1 {
+		err := storage.Errorf(storage.CodeInvalidRequest, "%v requires an Index with multiple storage modules", request.RequestType)
+		storage.Reject(request, err)
+		return true
+	}
+	forward := *request
+	forward.Reply = make(chan interface{}, 1)
+	if !r.send(name, &forward, quit) {
+		return false
+	}
+	go func() {
+		defer close(request.Reply)
+		for reply := range forward.Reply {
+			if id, ok := reply.(string); ok {
+				reply = name + snapshotSeparator + id
+			}
+			request.RespondWithin(reply, ReplyTimeout)
+		}
+	}()
+	return true
+}
+
+// fetchIndexes broadcasts a TypeFetchIndexes request to every storage module and replies with all of the Indexes,
+// or with the first error returned by any module.
+func (r *StorageRouter) fetchIndexes(request *storage.Request, quit chan struct{}) bool {
+	if len(r.names) == 1 {
+		return r.send(r.names[0], request, quit)
+	}
+	replies := make([]chan interface{}, 0, len(r.names))
+	for _, name := range r.names {
+		forward := *request
+		forward.Reply = make(chan interface{}, 1)
+		if !r.send(name, &forward, quit) {
+			return false
+		}
+		replies = append(replies, forward.Reply)
+	}
+	go func() {
+		defer close(request.Reply)
+		seen := make(map[string]bool)
+		indexList := []string{}
+		var err error
+		for _, replyChannel := range replies {
+			for reply := range replyChannel {
+				switch reply := reply.(type) {
+				case []string:
+					for _, index := range reply {
+						if !seen[index] {
+							seen[index] = true
+							indexList = append(indexList, index)
+						}
+					}
+				case error:
+					if err == nil {
+						err = reply
+					}
+				}
+			}
+		}
+		if err != nil {
+			request.RespondWithin(err, ReplyTimeout)
+			return
+		}
+		request.RespondWithin(indexList, ReplyTimeout)
+	}()
+	return true
+}
+
+// batch forwards a TypeBatch request to the storage module of its operations. If the operations are for several
+// modules, each module is sent a TypeBatch request with its operations, and the results are merged in order.
+func (r *StorageRouter) batch(request *storage.Request, quit chan struct{}) bool {
+	split := storage.SplitBatch(request, func(op *storage.Request) string {
+		return r.Module(op.Index)
+	})
+	switch len(split.Targets) {
+	case 0:
+		return r.send(r.Module(request.Index), request, quit)
+	case 1:
+		return r.send(split.Targets[0], request, quit)
+	}
+	for n, name := range split.Targets {
+		if !r.send(name, split.Requests[n], quit) {
+			return false
+		}
+	}
+	go split.Merge()
+	return true
+}
+
+// logRoutes logs the storage modules and static routes of the router.
+func (r *StorageRouter) logRoutes(logger *zap.Logger) {
+	logger.Info("Routing Storage Requests",
+		zap.Strings("modules", r.names),
+		zap.String("default", r.fallback),
+	)
+	for index, name := range r.routes {
+		logger.Debug("Storage Route",
+			zap.String("index", index),
+			zap.String("module", name),
+		)
+	}
+}
@@ -0,0 +1,193 @@
+package inmemory
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/jbvmio/modules/storage"
+)
+
+// limiter tracks the entries of every Database of a Datastore to enforce its memory limits.
+type limiter struct {
+	limits storage.Limits
+	events bool
+
+	// lock protects the Evictor of the Datastore and the Evictor of every Database.
+	lock    sync.Mutex
+	entries *storage.Evictor[entryKey]
+	evicted uint64
+}
+
+// entryKey identifies an Entry across all Databases.
+type entryKey struct {
+	db    *Database
+	entry string
+}
+
+// SetLimits sets the memory limits of the Datastore, which are enforced whenever an Entry is set by a TypeSetEntry
+// Request. If events is true, every eviction is sent to matching watches as an EventEvict. It must be called before
+// any Database is added.
+func (D *Datastore) SetLimits(limits storage.Limits, events bool) {
+	if !limits.Enabled() {
+		D.limiter = nil
+		return
+	}
+	D.limiter = &limiter{
+		limits: limits,
+		events: events,
+	}
+	if limits.Global() {
+		D.limiter.entries = storage.NewEvictor[entryKey](limits.Policy)
+	}
+}
+
+// Evicted returns the number of entries evicted by the memory limits of the Datastore.
+func (D *Datastore) Evicted() uint64 {
+	if D.limiter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&D.limiter.evicted)
+}
+
+// track starts tracking the entries of a new Database under the memory limits, with the names it is stored under.
+func (db *Database) track(l *limiter, index, name string) {
+	if l == nil {
+		return
+	}
+	db.limiter = l
+	db.index = index
+	db.name = name
+	if l.limits.MaxDBEntries > 0 {
+		db.evictor = storage.NewEvictor[string](l.limits.Policy)
+	}
+}
+
+// added tracks an Entry added to the Database. The Database must be locked by the caller.
+func (db *Database) added(entry string, data Entry) {
+	l := db.limiter
+	if l == nil {
+		return
+	}
+	size := storage.EntrySize(entry, data.Get())
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if db.evictor != nil {
+		db.evictor.Add(entry, size)
+	}
+	if l.entries != nil {
+		l.entries.Add(entryKey{db: db, entry: entry}, size)
+	}
+}
+
+// removed stops tracking an Entry removed from the Database. The Database must be locked by the caller.
+func (db *Database) removed(entry string) {
+	l := db.limiter
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if db.evictor != nil {
+		db.evictor.Remove(entry)
+	}
+	if l.entries != nil {
+		l.entries.Remove(entryKey{db: db, entry: entry})
+	}
+}
+
+// accessed records a fetch of the Entry, for the LRU and LFU eviction policies. The Database must be locked by the
+// caller.
+func (db *Database) accessed(entry string) {
+	l := db.limiter
+	if l == nil || l.limits.Policy == storage.EvictFIFO {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if db.evictor != nil {
+		db.evictor.Touch(entry)
+	}
+	if l.entries != nil {
+		l.entries.Touch(entryKey{db: db, entry: entry})
+	}
+}
+
+// evictDB evicts entries of the Database over the MaxDBEntries limit, other than the Entry just set, and returns the
+// evictions. The Database must be locked by the caller.
+func (db *Database) evictDB(set string) []*Event {
+	l := db.limiter
+	if l == nil || l.limits.MaxDBEntries == 0 {
+		return nil
+	}
+	var evicted []*Event
+	for {
+		l.lock.Lock()
+		entry, ok := "", db.evictor.Len() > l.limits.MaxDBEntries
+		if ok {
+			entry, ok = db.evictor.Victim(set)
+		}
+		l.lock.Unlock()
+		if !ok {
+			return evicted
+		}
+		evicted = append(evicted, db.evict(entry))
+	}
+}
+
+// evict evicts entries across all Databases over the MaxEntries and MaxBytes limits, other than the Entry just set
+// in the Database, and returns the evictions. The Database must not be locked by the caller, as the entries evicted
+// can be in any Database.
+func (D *Datastore) evict(db *Database, set string) []*Event {
+	l := D.limiter
+	if l == nil || l.entries == nil {
+		return nil
+	}
+	var evicted []*Event
+	for {
+		l.lock.Lock()
+		var victim entryKey
+		ok := l.limits.Over(l.entries.Len(), l.entries.Bytes())
+		if ok {
+			victim, ok = l.entries.Victim(entryKey{db: db, entry: set})
+		}
+		l.lock.Unlock()
+		if !ok {
+			return evicted
+		}
+
+		victim.db.Lock()
+		if _, found := victim.db.entries[victim.entry]; found {
+			evicted = append(evicted, victim.db.evict(victim.entry))
+		} else {
+			// Removed since it was chosen
+			l.lock.Lock()
+			l.entries.Remove(victim)
+			l.lock.Unlock()
+		}
+		victim.db.Unlock()
+	}
+}
+
+// evict removes the Entry from the Database and returns the eviction. The Database must be locked by the caller.
+func (db *Database) evict(entry string) *Event {
+	old := db.entries[entry]
+	db.DeleteEntry(entry)
+	atomic.AddUint64(&db.limiter.evicted, 1)
+	return &Event{
+		Type:  EventEvict,
+		Index: db.index,
+		DB:    db.name,
+		Entry: entry,
+		Old:   old,
+	}
+}
+
+// notifyEvicted sends the evictions to watches, if the Datastore is configured to send eviction events.
+func (D *Datastore) notifyEvicted(evicted []*Event) {
+	if D.limiter == nil || !D.limiter.events {
+		return
+	}
+	for _, event := range evicted {
+		D.Notify(event)
+	}
+}
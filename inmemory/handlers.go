@@ -37,6 +37,13 @@ func deleteEntry(r team.TaskRequest) {
 	}
 	db.DeleteEntry(request.Entry)
 	db.Unlock()
+	moduleStorage.Notify(&Event{
+		Type:  EventDelete,
+		Index: request.Index,
+		DB:    request.DB,
+		Entry: request.Entry,
+		Old:   entry,
+	})
 	Logger.Debug("ok")
 }
 
@@ -96,6 +103,7 @@ func fetchEntry(r team.TaskRequest) {
 		db.RUnlock()
 		return
 	}
+	db.accessed(request.Entry)
 	db.RUnlock()
 
 	Logger.Debug("ok", zap.String("fetch entry", request.Entry))
@@ -151,6 +159,7 @@ func addEntry(r team.TaskRequest) {
 		if db.err.(Err).Code() == ErrUnknownDB {
 			Logger.Debug("Creating New Database", zap.String("database", request.DB))
 			db = NewDatabase()
+			db.track(moduleStorage.limiter, request.Index, request.DB)
 			index.AddDB(request.DB, db)
 		} else {
 			Logger.Error("Error Retrieving Database",
@@ -163,8 +172,23 @@ func addEntry(r team.TaskRequest) {
 
 	index.Unlock()
 	db.Lock()
-	defer db.Unlock()
+	old := db.GetEntry(request.Entry)
+	if old.Err() != nil {
+		old = nil
+	}
 	db.AddEntry(request.Entry, request.Data)
+	evicted := db.evictDB(request.Entry)
+	db.Unlock()
+	evicted = append(evicted, moduleStorage.evict(db, request.Entry)...)
+	moduleStorage.Notify(&Event{
+		Type:  EventSet,
+		Index: request.Index,
+		DB:    request.DB,
+		Entry: request.Entry,
+		Old:   old,
+		New:   request.Data,
+	})
+	moduleStorage.notifyEvicted(evicted)
 	Logger.Debug("ok")
 	return
 }
@@ -2,6 +2,8 @@ package inmemory
 
 import (
 	"sync"
+
+	"github.com/jbvmio/modules/storage"
 )
 
 // Global Variables
@@ -18,13 +20,18 @@ type Datastore struct {
 	indexes map[string]*Index
 	// Mutex for all Indexes
 	idx *sync.RWMutex
+	// Active TypeWatch Requests
+	watchers *watchers
+	// Memory limits, if any are set
+	limiter *limiter
 }
 
 // New creates and returns a new Datastore.
 func New() *Datastore {
 	return &Datastore{
-		indexes: make(map[string]*Index),
-		idx:     &sync.RWMutex{},
+		indexes:  make(map[string]*Index),
+		idx:      &sync.RWMutex{},
+		watchers: newWatchers(),
 	}
 }
 
@@ -74,6 +81,12 @@ type Database struct {
 	lastAccess int64
 	// err holds any errors encountered during the operational process.
 	err error
+	// limiter tracks the entries under the memory limits of the Datastore, with evictor ordering them for the
+	// MaxDBEntries limit. index and name are the names the Database is stored under, for evictions.
+	limiter *limiter
+	evictor *storage.Evictor[string]
+	index   string
+	name    string
 }
 
 // NewIndex returns a new Index.
@@ -177,17 +190,19 @@ func (db *Database) GetEntry(entry string) Entry {
 
 // AddData adds a Data Entry to the Database.
 func (db *Database) AddData(entry string, data interface{}) {
-	db.entries[entry] = &Data{Item: data}
+	db.AddEntry(entry, &Data{Item: data})
 }
 
 // AddEntry adds an Entry to the Database.
 func (db *Database) AddEntry(entry string, data Entry) {
 	db.entries[entry] = data
+	db.added(entry, data)
 }
 
 // DeleteEntry deletes the specified Entry from the Database.
 func (db *Database) DeleteEntry(entry string) {
 	delete(db.entries, entry)
+	db.removed(entry)
 }
 
 // EntryMap returns the direct EntryMap for the Database.
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/jbvmio/modules/storage"
 	"github.com/jbvmio/team"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -29,6 +30,15 @@ type Config struct {
 	QueueDepth      int
 	MaxReqTime      int
 	DiscardTimeouts bool
+
+	// Memory limits, enforced when an Entry is set. A zero limit is unlimited.
+	MaxEntries   int
+	MaxDBEntries int
+	MaxBytes     int64
+	// EvictionPolicy is either lru, lfu or fifo. Defaults to lru.
+	EvictionPolicy string
+	// EvictEvents sends every eviction to matching watches as an EventEvict.
+	EvictEvents bool
 }
 
 // NewConfig returns a new default Config.
@@ -69,6 +79,16 @@ func NewModule(config *Config) *Module {
 	AutoIndex = config.AutoIndex
 	module.Config = config
 	moduleStorage = New()
+	policy, err := storage.ParseEvictionPolicy(config.EvictionPolicy)
+	if err != nil {
+		fmt.Printf("Invalid eviction policy supplied. Defaulting to lru: %s", config.EvictionPolicy)
+	}
+	moduleStorage.SetLimits(storage.Limits{
+		MaxEntries:   config.MaxEntries,
+		MaxDBEntries: config.MaxDBEntries,
+		MaxBytes:     config.MaxBytes,
+		Policy:       policy,
+	}, config.EvictEvents)
 	return &module
 }
 
@@ -94,6 +114,7 @@ func (m *Module) Start() {
 
 // Stop starts the Module.
 func (m *Module) Stop() {
+	moduleStorage.StopWatches()
 	m.Process.Stop()
 	switch m.Process.Logger.(type) {
 	case *zap.Logger:
@@ -0,0 +1,260 @@
+package inmemory
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jbvmio/team"
+	"go.uber.org/zap"
+)
+
+// Operator compares a field of an Entry against the Value of a Filter.
+type Operator string
+
+// Operator Constants
+const (
+	OpEqual        Operator = "=="
+	OpNotEqual     Operator = "!="
+	OpLess         Operator = "<"
+	OpLessEqual    Operator = "<="
+	OpGreater      Operator = ">"
+	OpGreaterEqual Operator = ">="
+)
+
+// Filter matches entries whose Field compares to Value using Op. Entries are compared in their JSON form, so Field
+// is a JSON field name, with nested fields separated by dots, and Value is any JSON-serializable value. Numbers and
+// strings support all operators, other values only OpEqual and OpNotEqual.
+type Filter struct {
+	Field string
+	Op    Operator
+	Value interface{}
+}
+
+// Predicate matches an Entry. Predicates are registered by name using RegisterPredicate.
+type Predicate func(Entry) bool
+
+// Query selects the entries of a Database returned by a TypeQuery Request. An Entry must match every Filter and the
+// Predicate, if set. Matching entries are sorted by the SortBy field, if set, and at most Limit entries are returned.
+// A Limit of 0 returns all matching entries.
+type Query struct {
+	Filters    []Filter
+	Predicate  string
+	SortBy     string
+	Descending bool
+	Limit      int
+}
+
+var predicates = struct {
+	sync.RWMutex
+	funcs map[string]Predicate
+}{
+	funcs: make(map[string]Predicate),
+}
+
+// RegisterPredicate registers a Predicate under the given name for use in a Query.
+func RegisterPredicate(name string, predicate Predicate) {
+	predicates.Lock()
+	predicates.funcs[name] = predicate
+	predicates.Unlock()
+}
+
+func getPredicate(name string) (Predicate, bool) {
+	predicates.RLock()
+	defer predicates.RUnlock()
+	predicate, ok := predicates.funcs[name]
+	return predicate, ok
+}
+
+// IsValid returns true if every Filter uses a known Operator and the Predicate, if set, is registered.
+func (q *Query) IsValid() bool {
+	if q.Limit < 0 {
+		return false
+	}
+	for _, f := range q.Filters {
+		if f.Field == "" {
+			return false
+		}
+		switch f.Op {
+		case OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		default:
+			return false
+		}
+	}
+	if q.Predicate != "" {
+		if _, ok := getPredicate(q.Predicate); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// queryMatch is an Entry matching a Query, along with its JSON form if needed for sorting.
+type queryMatch struct {
+	entry Entry
+	doc   interface{}
+}
+
+// Run returns the entries matching the Query. Values which cannot be converted to JSON never match a Filter.
+func (q *Query) Run(entries map[string]Entry) []Entry {
+	predicate, _ := getPredicate(q.Predicate)
+	filters := make([]Filter, len(q.Filters))
+	for i, f := range q.Filters {
+		filters[i] = Filter{Field: f.Field, Op: f.Op, Value: normalize(f.Value)}
+	}
+	needDoc := len(filters) > 0 || q.SortBy != ""
+
+	var matches []queryMatch
+matchEntries:
+	for _, entry := range entries {
+		if predicate != nil && !predicate(entry) {
+			continue
+		}
+		var doc interface{}
+		if needDoc {
+			var ok bool
+			if doc, ok = toDoc(entry.Get()); !ok {
+				continue
+			}
+		}
+		for _, f := range filters {
+			if !f.match(lookup(doc, f.Field)) {
+				continue matchEntries
+			}
+		}
+		matches = append(matches, queryMatch{entry: entry, doc: doc})
+		if q.SortBy == "" && q.Limit > 0 && len(matches) == q.Limit {
+			break
+		}
+	}
+
+	if q.SortBy != "" {
+		sort.SliceStable(matches, func(i, j int) bool {
+			a, b := lookup(matches[i].doc, q.SortBy), lookup(matches[j].doc, q.SortBy)
+			if q.Descending {
+				a, b = b, a
+			}
+			return less(a, b)
+		})
+		if q.Limit > 0 && len(matches) > q.Limit {
+			matches = matches[:q.Limit]
+		}
+	}
+
+	result := make([]Entry, len(matches))
+	for i, m := range matches {
+		result[i] = m.entry
+	}
+	return result
+}
+
+func (f *Filter) match(v interface{}) bool {
+	switch f.Op {
+	case OpEqual:
+		return reflect.DeepEqual(v, f.Value)
+	case OpNotEqual:
+		return !reflect.DeepEqual(v, f.Value)
+	}
+	c, ok := compare(v, f.Value)
+	if !ok {
+		return false
+	}
+	switch f.Op {
+	case OpLess:
+		return c < 0
+	case OpLessEqual:
+		return c <= 0
+	case OpGreater:
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// compare orders two numbers or two strings. It returns false for any other values.
+func compare(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+// less orders values for sorting. Values which cannot be compared, such as missing fields, sort last.
+func less(a, b interface{}) bool {
+	if c, ok := compare(a, b); ok {
+		return c < 0
+	}
+	_, aOK := compare(a, a)
+	_, bOK := compare(b, b)
+	return aOK && !bOK
+}
+
+// toDoc returns the JSON form of a value as decoded by encoding/json.
+func toDoc(v interface{}) (interface{}, bool) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, false
+	}
+	return doc, true
+}
+
+// normalize converts a Filter value to its JSON form so it compares equal to decoded fields, such as an int to a
+// float64.
+func normalize(v interface{}) interface{} {
+	doc, _ := toDoc(v)
+	return doc
+}
+
+// lookup returns the value of a dotted field path in a decoded JSON document, or nil if it does not exist.
+func lookup(doc interface{}, field string) interface{} {
+	for _, name := range strings.Split(field, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = m[name]
+	}
+	return doc
+}
+
+func query(r team.TaskRequest) {
+	request := r.(*Request)
+	defer close(request.Reply)
+	Logger.Debug("Querying Entries", zap.String("index", request.Index),
+		zap.String("database", request.DB),
+	)
+
+	db := moduleStorage.Get(request.Index).GetDB(request.DB)
+	if db.err != nil {
+		Logger.Error("Error Retrieving Database",
+			zap.Error(db.err),
+		)
+		return
+	}
+
+	db.RLock()
+	result := request.Query.Run(*db.EntryMap())
+	db.RUnlock()
+
+	Logger.Debug("ok", zap.Int("matches", len(result)))
+	request.Reply <- result
+}
@@ -42,6 +42,15 @@ const (
 
 	// TypeFetchAllEntries is the request type to retrieve all entries in a database. Returns a []interface{}
 	TypeFetchAllEntries RequestConstant = 7
+
+	// TypeWatch is the request type to receive change notifications for an Index, a Database or Entry key prefix.
+	// Requires Reply, Done and Index. Sends an *Event over Reply for every change until Done is closed. Reply must be
+	// buffered: if it is full when an Event is sent, the watch is closed and Reply is closed.
+	TypeWatch RequestConstant = 8
+
+	// TypeQuery is the request type to retrieve the entries in a database matching a Query. Requires Reply, Index,
+	// DB and Query. Returns a []Entry
+	TypeQuery RequestConstant = 9
 )
 
 var storageRequestStrings = [...]string{
@@ -53,6 +62,8 @@ var storageRequestStrings = [...]string{
 	"TypeFetchEntry",
 	"TypeFetchDatabases",
 	"TypeFetchAllEntries",
+	"TypeWatch",
+	"TypeQuery",
 }
 
 // String returns a string representation of a StorageRequestConstant for logging
@@ -98,6 +109,9 @@ type Request struct {
 	// If the RequestType is a "Fetch" request, Reply must contain a channel to receive the response on
 	Reply chan interface{}
 
+	// If the RequestType is a "Watch" request, Done must contain a channel which is closed to cancel the watch
+	Done chan struct{}
+
 	// The name of the cluster to which the request applies. Required for all request types except StorageFetchClusters
 	Index string
 
@@ -110,6 +124,9 @@ type Request struct {
 	// The timestamp of the request
 	Timestamp int64
 
+	// The filters, sort and limit of a TypeQuery request
+	Query *Query
+
 	// Interface holding data
 	Data Entry
 }
@@ -129,6 +146,19 @@ func (r *Request) Get() interface{} {
 	return r.Data
 }
 
+// Cancel cancels a TypeWatch Request by closing its Done channel. The Module then closes the Reply channel.
+// Calling Cancel more than once is safe, but it must not be called concurrently.
+func (r *Request) Cancel() {
+	if r.Done == nil {
+		return
+	}
+	select {
+	case <-r.Done:
+	default:
+		close(r.Done)
+	}
+}
+
 // ConsistID is the identifier in the request that is used for consistent requests.
 func (r *Request) ConsistID() string {
 	return r.DB + r.Entry
@@ -143,11 +173,13 @@ var requestMap = team.RequestMap{
 		int(TypeFetchDatabases): fetchDBList,
 		int(TypeFetchEntries):   fetchEntryList,
 		int(TypeFetchEntry):     fetchEntry,
+		int(TypeWatch):          addWatch,
 	},
 	Consistent: map[int]team.RequestHandleFunc{
 		int(TypeSetEntry):        addEntry,
 		int(TypeDeleteEntry):     deleteEntry,
 		int(TypeFetchEntry):      fetchEntry,
 		int(TypeFetchAllEntries): fetchAllEntries,
+		int(TypeQuery):           query,
 	},
 }
@@ -2,6 +2,8 @@ package inmemory
 
 import (
 	"time"
+
+	"github.com/jbvmio/modules/storage"
 )
 
 // RequestBuilder helps build a Request using chains.
@@ -13,6 +15,9 @@ type RequestBuilder struct {
 	// If the RequestType is a "Fetch" request, Reply must contain a channel to receive the response on
 	Reply chan interface{}
 
+	// If the RequestType is a "Watch" request, Done must contain a channel which is closed to cancel the watch
+	Done chan struct{}
+
 	// The name of the cluster to which the request applies. Required for all request types except StorageFetchClusters
 	Index string
 
@@ -25,6 +30,9 @@ type RequestBuilder struct {
 	// The timestamp of the request
 	Timestamp int64
 
+	// The filters, sort and limit of a TypeQuery request
+	Query *Query
+
 	// Interface holding data
 	Data Entry
 }
@@ -47,14 +55,22 @@ func BuildRequest() *RequestBuilder {
 // SetRequestType sets the Corresponding Request Type.
 func (sr *RequestBuilder) SetRequestType(requestType RequestConstant) *RequestBuilder {
 	switch requestType {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry:
+	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeQuery:
 		sr.Reply = make(chan interface{})
+	case TypeWatch:
+		sr.Reply = make(chan interface{}, WatchBufferSize)
+		sr.Done = make(chan struct{})
 	}
 	req := RequestID{id: requestType, name: requestType.String()}
 	sr.RequestType = req
 	return sr
 }
 
+// SetRequestWatch sets the Request Type to TypeWatch. The Entry, if set, is used as a key prefix.
+func (sr *RequestBuilder) SetRequestWatch() *RequestBuilder {
+	return sr.SetRequestType(TypeWatch)
+}
+
 // SetIndex sets the index for the Storage Request.
 func (sr *RequestBuilder) SetIndex(index string) *RequestBuilder {
 	sr.Index = index
@@ -79,6 +95,12 @@ func (sr *RequestBuilder) AddData(data interface{}) *RequestBuilder {
 	return sr
 }
 
+// SetQuery sets the Query for a TypeQuery Storage Request.
+func (sr *RequestBuilder) SetQuery(query *Query) *RequestBuilder {
+	sr.Query = query
+	return sr
+}
+
 // AddEntry attaches an Entry type for the Storage Request.
 func (sr *RequestBuilder) AddEntry(data Entry) *RequestBuilder {
 	sr.Data = data
@@ -90,71 +112,31 @@ func (sr *RequestBuilder) AddEntry(data Entry) *RequestBuilder {
 // This does not validate the Request.
 func (sr *RequestBuilder) CreateRequest() *Request {
 	switch sr.RequestType.id {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeFetchDatabases, TypeFetchAllEntries:
+	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeFetchDatabases, TypeFetchAllEntries, TypeQuery:
 		if sr.Reply == nil {
 			sr.Reply = make(chan interface{})
 		}
+	case TypeWatch:
+		if sr.Reply == nil {
+			sr.Reply = make(chan interface{}, WatchBufferSize)
+		}
+		if sr.Done == nil {
+			sr.Done = make(chan struct{})
+		}
 	}
 	return convertFromBuilder(sr)
 }
 
 // IsValid validates the RequestBuilder for all fields and returns true if valdation passes.
+// Use Check to find out why validation failed.
 func (sr *RequestBuilder) IsValid() bool {
-validateRequest:
-	switch sr.RequestType.id {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeFetchDatabases, TypeFetchAllEntries:
-		switch {
-		case sr.Reply == nil:
-			break validateRequest
-		case sr.Index == "":
-			if sr.RequestType.id == TypeFetchEntries || sr.RequestType.id == TypeFetchEntry || sr.RequestType.id == TypeFetchAllEntries || sr.RequestType.id == TypeFetchDatabases {
-				break validateRequest
-			}
-			fallthrough
-		case sr.DB == "":
-			if sr.RequestType.id == TypeFetchEntries || sr.RequestType.id == TypeFetchEntry || sr.RequestType.id == TypeFetchAllEntries {
-				break validateRequest
-			}
-			fallthrough
-		case sr.Entry == "":
-			if sr.RequestType.id == TypeFetchEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType.id == TypeFetchIndexes {
-				if sr.DB != "" || sr.Index != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			if sr.RequestType.id == TypeFetchEntries {
-				if sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			return true
-		}
-	case TypeSetIndex, TypeSetEntry, TypeDeleteEntry:
-		switch {
-		case sr.Reply != nil:
-			break validateRequest
-		case sr.Index == "":
-			break validateRequest
-		case sr.DB == "" || sr.Entry == "":
-			if sr.RequestType.id == TypeSetEntry || sr.RequestType.id == TypeDeleteEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType.id == TypeSetIndex {
-				if sr.DB != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			return true
-		}
-	}
-	return false
+	return sr.Check() == nil
+}
+
+// Check validates the RequestBuilder and returns a *storage.ValidationError describing every missing or forbidden
+// field, or nil if it is valid.
+func (sr *RequestBuilder) Check() error {
+	return convertFromBuilder(sr).Check()
 }
 
 // CreateRequest takes a RequestBuilder, validates it and returns a Request and true if validation passes.
@@ -162,15 +144,24 @@ validateRequest:
 // If validation does not pass, the returned Request will be nil.
 func CreateRequest(sr *RequestBuilder) (*Request, bool) {
 	switch sr.RequestType.id {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeFetchDatabases, TypeFetchAllEntries:
+	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeFetchDatabases, TypeFetchAllEntries, TypeQuery:
 		if sr.Reply == nil {
 			sr.Reply = make(chan interface{})
 		}
+	case TypeWatch:
+		if sr.Reply == nil {
+			sr.Reply = make(chan interface{}, WatchBufferSize)
+		}
+		if sr.Done == nil {
+			sr.Done = make(chan struct{})
+		}
 	}
 	if ok := sr.IsValid(); ok {
 		return convertFromBuilder(sr), ok
 	}
-	close(sr.Reply)
+	if sr.Reply != nil {
+		close(sr.Reply)
+	}
 	return nil, false
 }
 
@@ -178,71 +169,70 @@ func convertFromBuilder(sr *RequestBuilder) *Request {
 	return &Request{
 		RequestType: sr.RequestType,
 		Reply:       sr.Reply,
+		Done:        sr.Done,
 		Index:       sr.Index,
 		DB:          sr.DB,
 		Entry:       sr.Entry,
 		Timestamp:   sr.Timestamp,
+		Query:       sr.Query,
 		Data:        sr.Data,
 	}
 }
 
 // IsValid validates the Storage Request for all fields and returns true if valdation passes.
+// Use Check to find out why validation failed.
 func (sr *Request) IsValid() bool {
-validateRequest:
+	return sr.Check() == nil
+}
+
+// requestRules describes the fields of each request type. The request types shared with the storage package use its
+// rules, so only those of this package are described here.
+var requestRules = func() map[RequestConstant]storage.FieldRule {
+	rules := map[RequestConstant]storage.FieldRule{
+		TypeFetchAllEntries: {
+			Required: []string{"Reply", "Index", "DB"},
+		},
+		TypeQuery: {
+			Required:  []string{"Reply", "Index", "DB", "Query"},
+			Forbidden: []string{"Entry"},
+		},
+	}
+	for c, name := range storageRequestStrings {
+		if rule, ok := storage.RequestRules(name); ok {
+			rules[RequestConstant(c)] = rule
+		}
+	}
+	return rules
+}()
+
+// Check validates the Request using the storage validation engine and returns a *storage.ValidationError describing
+// every problem found, or nil if it is valid.
+func (sr *Request) Check() error {
+	v := storage.NewValidator(sr.RequestType.id)
+	rule, ok := requestRules[sr.RequestType.id]
+	if !ok {
+		v.Invalidf("unknown request type %d", sr.RequestType.id)
+		return v.Err()
+	}
+	v.CheckFields("", rule, map[string]bool{
+		"Reply": sr.Reply != nil,
+		"Done":  sr.Done != nil,
+		"Index": sr.Index != "",
+		"DB":    sr.DB != "",
+		"Entry": sr.Entry != "",
+		"Query": sr.Query != nil,
+	})
 	switch sr.RequestType.id {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeFetchDatabases, TypeFetchAllEntries:
-		switch {
-		case sr.Reply == nil:
-			break validateRequest
-		case sr.Index == "":
-			if sr.RequestType.id == TypeFetchEntries || sr.RequestType.id == TypeFetchEntry || sr.RequestType.id == TypeFetchAllEntries || sr.RequestType.id == TypeFetchDatabases {
-				break validateRequest
-			}
-			fallthrough
-		case sr.DB == "":
-			if sr.RequestType.id == TypeFetchEntries || sr.RequestType.id == TypeFetchEntry || sr.RequestType.id == TypeFetchAllEntries {
-				break validateRequest
-			}
-			fallthrough
-		case sr.Entry == "":
-			if sr.RequestType.id == TypeFetchEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType.id == TypeFetchIndexes {
-				if sr.DB != "" || sr.Index != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			if sr.RequestType.id == TypeFetchEntries {
-				if sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			return true
+	case TypeWatch:
+		if sr.Entry != "" && sr.DB == "" {
+			v.Invalidf("Entry prefix requires DB")
 		}
-	case TypeSetIndex, TypeSetEntry, TypeDeleteEntry:
-		switch {
-		case sr.Reply != nil:
-			break validateRequest
-		case sr.Index == "":
-			break validateRequest
-		case sr.DB == "" || sr.Entry == "":
-			if sr.RequestType.id == TypeSetEntry || sr.RequestType.id == TypeDeleteEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType.id == TypeSetIndex {
-				if sr.DB != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			return true
+	case TypeQuery:
+		if sr.Query != nil && !sr.Query.IsValid() {
+			v.Invalidf("Query requires a non-negative Limit, a Field and known Operator for each Filter and a registered Predicate")
 		}
 	}
-	return false
+	return v.Err()
 }
 
 // TimeoutSendStorageRequest sends a Request to a channel with a timeout,
@@ -0,0 +1,163 @@
+package inmemory
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/jbvmio/team"
+	"go.uber.org/zap"
+)
+
+// EventConstant is used in Event to indicate the type of change.
+type EventConstant int
+
+const (
+	// EventSet is sent when an Entry is added or replaced. Old is nil if the Entry did not exist.
+	EventSet EventConstant = 0
+
+	// EventDelete is sent when an Entry is deleted. New is always nil.
+	EventDelete EventConstant = 1
+
+	// EventEvict is sent when an Entry is evicted by the memory limits of the Datastore, if it is configured to send
+	// eviction events. New is always nil.
+	EventEvict EventConstant = 2
+)
+
+var eventStrings = [...]string{
+	"EventSet",
+	"EventDelete",
+	"EventEvict",
+}
+
+// String returns a string representation of an EventConstant for logging
+func (c EventConstant) String() string {
+	if (c >= 0) && (c < EventConstant(len(eventStrings))) {
+		return eventStrings[c]
+	}
+	return "UNKNOWN"
+}
+
+// WatchBufferSize is the size of the Reply channel created for a TypeWatch request.
+var WatchBufferSize = 64
+
+// Event is sent over the Reply channel of a TypeWatch request for every change matching the watch.
+type Event struct {
+	Type  EventConstant
+	Index string
+	DB    string
+	Entry string
+	Old   Entry
+	New   Entry
+}
+
+// Matches returns true if the Event falls under the Index, Database and Entry key prefix of the given Request.
+func (e *Event) Matches(watch *Request) bool {
+	switch {
+	case e.Index != watch.Index:
+		return false
+	case watch.DB != "" && e.DB != watch.DB:
+		return false
+	default:
+		return strings.HasPrefix(e.Entry, watch.Entry)
+	}
+}
+
+// watchers holds all active TypeWatch requests for a Datastore.
+type watchers struct {
+	lock    sync.RWMutex
+	running sync.WaitGroup
+	stop    chan struct{}
+	watches map[*Request]*watch
+}
+
+// watch is an active TypeWatch Request. Overflow is closed once an Event could not be sent because the Reply
+// channel was full.
+type watch struct {
+	request  *Request
+	overflow chan struct{}
+	once     sync.Once
+}
+
+func newWatchers() *watchers {
+	return &watchers{
+		stop:    make(chan struct{}),
+		watches: make(map[*Request]*watch),
+	}
+}
+
+// Watch registers a TypeWatch Request with the Datastore. The Reply channel is closed once the
+// Request is cancelled, the Reply channel overflows or the Datastore watches are stopped.
+func (D *Datastore) Watch(request *Request) {
+	w := &watch{
+		request:  request,
+		overflow: make(chan struct{}),
+	}
+	D.watchers.lock.Lock()
+	D.watchers.watches[request] = w
+	D.watchers.lock.Unlock()
+
+	// Events are only sent while holding the read lock, so nothing is sent on Reply after it is closed here.
+	D.watchers.running.Add(1)
+	go func() {
+		defer D.watchers.running.Done()
+		select {
+		case <-request.Done:
+		case <-w.overflow:
+			Logger.Warn("Watch Overflowed", zap.String("index", request.Index),
+				zap.String("database", request.DB),
+				zap.String("entry", request.Entry),
+			)
+		case <-D.watchers.stop:
+		}
+		D.watchers.lock.Lock()
+		delete(D.watchers.watches, request)
+		D.watchers.lock.Unlock()
+		close(request.Reply)
+	}()
+}
+
+// Notify sends the given Event to every matching watch. Events are sent by the worker handling the change, so
+// sending never blocks: a watch whose Reply channel is full is closed instead, and receives no further Events.
+func (D *Datastore) Notify(event *Event) {
+	D.watchers.lock.RLock()
+	defer D.watchers.lock.RUnlock()
+	for _, w := range D.watchers.watches {
+		if event.Matches(w.request) {
+			w.send(event)
+		}
+	}
+}
+
+// send sends the Event without blocking, and closes the watch if its Reply channel is full.
+func (w *watch) send(event *Event) {
+	select {
+	case <-w.overflow:
+		return
+	default:
+	}
+	select {
+	case w.request.Reply <- event:
+	default:
+		w.once.Do(func() { close(w.overflow) })
+	}
+}
+
+// StopWatches closes all active watches and waits for them to be removed.
+func (D *Datastore) StopWatches() {
+	select {
+	case <-D.watchers.stop:
+	default:
+		close(D.watchers.stop)
+	}
+	D.watchers.running.Wait()
+}
+
+func addWatch(r team.TaskRequest) {
+	request := r.(*Request)
+	Logger.Debug("Adding Watch", zap.String("index", request.Index),
+		zap.String("database", request.DB),
+		zap.String("entry", request.Entry),
+	)
+	moduleStorage.Watch(request)
+	Logger.Debug("ok")
+}
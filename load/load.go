@@ -2,7 +2,11 @@ package load
 
 import (
 	"github.com/jbvmio/modules/coop"
+	"github.com/jbvmio/modules/storage/disk"
 	"github.com/jbvmio/modules/storage/inmemory"
+	"github.com/jbvmio/modules/storage/replication"
+	"github.com/jbvmio/modules/storage/sharded"
+	"github.com/jbvmio/modules/storage/tiered"
 )
 
 // ModuleInMemory loads the inmemory Module.
@@ -10,6 +14,46 @@ func ModuleInMemory() {
 	coop.PackageModules[0] = &inmemory.InMemoryModule{}
 }
 
+// ModuleDisk loads the disk Module.
+func ModuleDisk() {
+	coop.PackageModules[0] = &disk.DiskModule{}
+}
+
+// ModuleSharded loads the sharded Module.
+func ModuleSharded() {
+	coop.PackageModules[0] = &sharded.ShardedModule{}
+}
+
+// ModuleReplicated loads the replicated Module.
+func ModuleReplicated() {
+	coop.PackageModules[0] = &replication.ReplicatedModule{}
+}
+
+// ModuleTiered loads the tiered Module.
+func ModuleTiered() {
+	coop.PackageModules[0] = &tiered.TieredModule{}
+}
+
+// ModuleStorage loads the storage Module matching the given class, either inmemory, disk, sharded, replicated or
+// tiered. Returns false if the class is unknown.
+func ModuleStorage(class string) bool {
+	switch class {
+	case "", "inmemory":
+		ModuleInMemory()
+	case "disk":
+		ModuleDisk()
+	case "sharded":
+		ModuleSharded()
+	case "replicated":
+		ModuleReplicated()
+	case "tiered":
+		ModuleTiered()
+	default:
+		return false
+	}
+	return true
+}
+
 // ModuleAdd adds an outside Module.
 func ModuleAdd(module coop.Module) {
 	coop.OutsideModules = append(coop.OutsideModules, module)
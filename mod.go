@@ -1,6 +1,7 @@
 package modules
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -56,31 +57,45 @@ func (m *Mod) BuildRequest() *storage.RequestBuilder {
 	return storage.BuildRequest()
 }
 
-// SendStorageRequest sends a request to the underlying Storage Channel
+// SendStorageRequest sends a request to the underlying Storage Channel.
+// Sending times out after 2 seconds, but waiting for a reply does not. Use SendStorageRequestContext to bound both.
 func (m *Mod) SendStorageRequest(sr *storage.Request) *storage.Response {
 	var response storage.Response
+	if err := sr.Check(); err != nil {
+		response.Failure = true
+		response.Err = err
+		return &response
+	}
 	switch {
 	case sr.Reply != nil:
 		ok := storage.TimeoutSendStorageRequest(m.StorageChannel(), sr, 2)
 		if !ok {
 			response.Failure = true
+			response.TimedOut = true
+			response.Err = storage.Errorf(storage.CodeTimeout, "sending %v", sr.RequestType)
 		} else {
-			r := <-sr.Reply
-			response.Failure = false
-			if r != nil {
-				response.Object = r.(*storage.Data).Object
-				response.HasObject = true
-			}
+			response.SetReply(<-sr.Reply)
 		}
 	default:
 		ok := storage.TimeoutSendStorageRequest(m.StorageChannel(), sr, 2)
 		if !ok {
 			response.Failure = true
+			response.TimedOut = true
+			response.Err = storage.Errorf(storage.CodeTimeout, "sending %v", sr.RequestType)
 		}
 	}
 	return &response
 }
 
+// SendStorageRequestContext sends a request to the underlying Storage Channel and waits for any reply.
+// The context covers both sending the request and waiting for the reply. If it is done first, the Response
+// has TimedOut set and Err matches both storage.ErrTimeout and the context error. The storage module skips a request
+// with a Reply if the context is done before the request is handled. Writes, which have no Reply, are always applied
+// once sent, even if the context is done first.
+func (m *Mod) SendStorageRequestContext(ctx context.Context, sr *storage.Request) *storage.Response {
+	return storage.SendRequestContext(ctx, m.StorageChannel(), sr)
+}
+
 // exitCode wraps a return value for the application
 type exitCode struct{ Code int }
 
@@ -3,6 +3,7 @@ package modules
 import (
 	"github.com/jbvmio/modules/coop"
 	"github.com/jbvmio/modules/load"
+	"github.com/spf13/viper"
 )
 
 // LoadInMemoryModule loads the InMemory Module
@@ -10,6 +11,36 @@ func (m *Mod) LoadInMemoryModule() {
 	load.ModuleInMemory()
 }
 
+// LoadDiskModule loads the Disk Module
+func (m *Mod) LoadDiskModule() {
+	load.ModuleDisk()
+}
+
+// LoadShardedModule loads the Sharded Module
+func (m *Mod) LoadShardedModule() {
+	load.ModuleSharded()
+}
+
+// LoadReplicatedModule loads the Replicated Module
+func (m *Mod) LoadReplicatedModule() {
+	load.ModuleReplicated()
+}
+
+// LoadTieredModule loads the Tiered Module
+func (m *Mod) LoadTieredModule() {
+	load.ModuleTiered()
+}
+
+// LoadStorageModule loads the storage Module set by storage.class, either inmemory, disk, sharded, replicated or
+// tiered.
+// If storage.class is not set, the InMemory Module is loaded.
+func (m *Mod) LoadStorageModule() {
+	class := viper.GetString("storage.class")
+	if !load.ModuleStorage(class) {
+		panic("unknown storage class: " + class)
+	}
+}
+
 // LoadModule loads the InMemory Module
 func (m *Mod) LoadModule(module coop.Module) {
 	load.ModuleAdd(module)
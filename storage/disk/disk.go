@@ -0,0 +1,427 @@
+package disk
+
+import (
+	"os"
+	"slices"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// database contains a map of entries, and their keys in sorted order once the database has been scanned.
+type database struct {
+	entries map[string]*storage.Data
+	keys    []string
+}
+
+func newDatabase() *database {
+	return &database{
+		entries: make(map[string]*storage.Data),
+	}
+}
+
+// set stores the Data of the Entry, adding the key of a new Entry to the sorted keys.
+func (db *database) set(entry string, data *storage.Data) {
+	if _, ok := db.entries[entry]; !ok && db.keys != nil {
+		i, _ := slices.BinarySearch(db.keys, entry)
+		db.keys = slices.Insert(db.keys, i, entry)
+	}
+	db.entries[entry] = data
+}
+
+// delete removes the Entry and its key.
+func (db *database) delete(entry string) {
+	if i, ok := slices.BinarySearch(db.keys, entry); ok {
+		db.keys = slices.Delete(db.keys, i, i+1)
+	}
+	delete(db.entries, entry)
+}
+
+// sortedKeys returns the keys of all entries in sorted order, including expired entries. The returned slice must not
+// be modified.
+func (db *database) sortedKeys() []string {
+	if db.keys == nil {
+		db.keys = make([]string, 0, len(db.entries))
+		for entry := range db.entries {
+			db.keys = append(db.keys, entry)
+		}
+		slices.Sort(db.keys)
+	}
+	return db.keys
+}
+
+// index contains a map of databases.
+type index map[string]*database
+
+// apply applies a mutation record to the in memory view of the data. It is used both when servicing requests
+// and when replaying snapshots and the write-ahead log, so it must not have any other side effects.
+func (module *DiskModule) apply(r *record) error {
+	if r.Version > module.version {
+		module.version = r.Version
+	}
+	switch r.RequestType {
+	case storage.TypeSetIndex:
+		if _, ok := module.indexes[r.Index]; !ok {
+			module.indexes[r.Index] = make(index)
+		}
+	case storage.TypeSetEntry:
+		idx, ok := module.indexes[r.Index]
+		if !ok {
+			idx = make(index)
+			module.indexes[r.Index] = idx
+		}
+		db, ok := idx[r.DB]
+		if !ok {
+			db = newDatabase()
+			idx[r.DB] = db
+		}
+		obj, err := r.decodeObject()
+		if err != nil {
+			return err
+		}
+		db.set(r.Entry, &storage.Data{
+			Object:   obj,
+			Version:  r.Version,
+			Modified: r.Modified,
+			Expires:  r.Expires,
+		})
+	case storage.TypeDeleteEntry:
+		idx, ok := module.indexes[r.Index]
+		if !ok {
+			return Errf(ErrUnknownIndex, "%v", r.Index)
+		}
+		db, ok := idx[r.DB]
+		if !ok {
+			return Errf(ErrUnknownDB, "%v", r.DB)
+		}
+		if _, ok := db.entries[r.Entry]; !ok {
+			return Errf(ErrUnknownEntry, "%v", r.Entry)
+		}
+		db.delete(r.Entry)
+	case storage.TypeDeleteDB:
+		if _, err := module.getDB(r.Index, r.DB); err != nil {
+			return err
+		}
+		delete(module.indexes[r.Index], r.DB)
+	case storage.TypeDeleteIndex:
+		if _, ok := module.indexes[r.Index]; !ok {
+			return Errf(ErrUnknownIndex, "%v", r.Index)
+		}
+		delete(module.indexes, r.Index)
+	}
+	return nil
+}
+
+// persist writes the request to the write-ahead log and then applies it.
+func (module *DiskModule) persist(request *storage.Request, requestLogger *zap.Logger) {
+	r, err := newRecord(request)
+	if err != nil {
+		requestLogger.Error("Error Encoding Object",
+			zap.Error(err),
+		)
+		return
+	}
+	if r.RequestType == storage.TypeSetEntry {
+		r.Version = module.version + 1
+		r.Modified = time.Now().UnixNano()
+	}
+	if err := module.wal.Append(r); err != nil {
+		requestLogger.Error("Error Writing Log",
+			zap.Error(err),
+		)
+		return
+	}
+	module.walRecords++
+	if err := module.apply(r); err != nil {
+		requestLogger.Error("Error Applying Request",
+			zap.Error(err),
+		)
+		return
+	}
+	requestLogger.Debug("ok")
+}
+
+func (module *DiskModule) addIndex(request *storage.Request, requestLogger *zap.Logger) {
+	if _, ok := module.indexes[request.Index]; ok {
+		requestLogger.Warn("Index Exists")
+		return
+	}
+	requestLogger.Debug("Adding Index")
+	module.persist(request, requestLogger)
+}
+
+func (module *DiskModule) addEntry(request *storage.Request, requestLogger *zap.Logger) {
+	if _, ok := module.indexes[request.Index]; !ok && !module.autoIndex {
+		requestLogger.Error("unknown index",
+			zap.String("index", request.Index),
+		)
+		return
+	}
+	requestLogger.Debug("Adding Data")
+	module.persist(request, requestLogger)
+}
+
+func (module *DiskModule) deleteEntry(request *storage.Request, requestLogger *zap.Logger) {
+	if _, err := module.getEntry(request.Index, request.DB, request.Entry); err != nil {
+		requestLogger.Error("Error Retrieving Entry",
+			zap.Error(err),
+		)
+		return
+	}
+	module.persist(request, requestLogger)
+}
+
+// increment services both TypeIncrement and TypeDecrement requests. The new value is written to the log as a
+// TypeSetEntry record.
+func (module *DiskModule) increment(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	if _, ok := module.indexes[request.Index]; !ok && !module.autoIndex {
+		err := Errf(ErrUnknownIndex, "%v", request.Index)
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	requestLogger.Debug("Updating Counter")
+
+	var current storage.Object
+	if data, err := module.getEntry(request.Index, request.DB, request.Entry); err == nil {
+		current = data.Object
+	}
+	value, err := storage.ApplyDelta(current, request)
+	if err != nil {
+		requestLogger.Error("Error Updating Counter",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	set := *request
+	set.RequestType = storage.TypeSetEntry
+	set.Object = value
+	module.persist(&set, requestLogger)
+
+	data, err := module.getEntry(request.Index, request.DB, request.Entry)
+	if err != nil {
+		request.Respond(err)
+		return
+	}
+	request.Respond(data)
+}
+
+func (module *DiskModule) deleteDB(request *storage.Request, requestLogger *zap.Logger) {
+	if _, err := module.getDB(request.Index, request.DB); err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		return
+	}
+	requestLogger.Debug("Deleting Database")
+	module.persist(request, requestLogger)
+}
+
+func (module *DiskModule) deleteIndex(request *storage.Request, requestLogger *zap.Logger) {
+	if _, ok := module.indexes[request.Index]; !ok {
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(Errf(ErrUnknownIndex, "%v", request.Index)),
+		)
+		return
+	}
+	requestLogger.Debug("Deleting Index")
+	module.persist(request, requestLogger)
+}
+
+func (module *DiskModule) fetchIndexList(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Fetching Indexes")
+
+	indexList := make([]string, 0, len(module.indexes))
+	for i := range module.indexes {
+		indexList = append(indexList, i)
+	}
+	requestLogger.Debug("ok")
+	request.Respond(indexList)
+}
+
+func (module *DiskModule) fetchDBList(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Fetching Databases")
+
+	idx, ok := module.indexes[request.Index]
+	if !ok {
+		err := Errf(ErrUnknownIndex, "%v", request.Index)
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	dbList := make([]string, 0, len(idx))
+	for db := range idx {
+		dbList = append(dbList, db)
+	}
+	requestLogger.Debug("ok")
+	request.Respond(dbList)
+}
+
+func (module *DiskModule) fetchEntryList(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Fetching Entries")
+
+	db, err := module.getDB(request.Index, request.DB)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	now := time.Now().UnixNano()
+	entryList := make([]string, 0, len(db.entries))
+	for entry, data := range db.entries {
+		if !data.Expired(now) {
+			entryList = append(entryList, entry)
+		}
+	}
+	requestLogger.Debug("ok")
+	request.Respond(entryList)
+}
+
+func (module *DiskModule) fetchEntry(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Fetching Entry")
+
+	data, err := module.getEntry(request.Index, request.DB, request.Entry)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Entry",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	requestLogger.Debug("ok")
+	request.Respond(data)
+}
+
+func (module *DiskModule) scan(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Scanning Entries")
+
+	db, err := module.getDB(request.Index, request.DB)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	now := time.Now().UnixNano()
+	result, err := request.Scan.Page(db.sortedKeys(), func(entry string) (*storage.Data, bool) {
+		data, ok := db.entries[entry]
+		return data, ok && !data.Expired(now)
+	})
+	if err != nil {
+		requestLogger.Error("Error Scanning Entries",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	requestLogger.Debug("ok")
+	request.Respond(result)
+}
+
+func (module *DiskModule) getDB(idx, db string) (*database, error) {
+	i, ok := module.indexes[idx]
+	if !ok {
+		return nil, Errf(ErrUnknownIndex, "%v", idx)
+	}
+	d, ok := i[db]
+	if !ok {
+		return nil, Errf(ErrUnknownDB, "%v", db)
+	}
+	return d, nil
+}
+
+// getEntry returns the specified Entry, or error if not found or expired.
+func (module *DiskModule) getEntry(idx, db, entry string) (*storage.Data, error) {
+	d, err := module.getDB(idx, db)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := d.entries[entry]
+	if !ok || data.Expired(time.Now().UnixNano()) {
+		return nil, Errf(ErrUnknownEntry, "%v", entry)
+	}
+	return data, nil
+}
+
+// snapshot writes the full data set to a new snapshot file, replaces the previous snapshot with it
+// and then truncates the write-ahead log.
+func (module *DiskModule) snapshot() error {
+	now := time.Now().UnixNano()
+	tmpFile := module.snapshotFile + ".tmp"
+	snap, err := openLog(tmpFile, 0, false)
+	if err != nil {
+		return err
+	}
+	for i, idx := range module.indexes {
+		// The last version is kept with every Index, so it is restored even if the entries with the highest versions
+		// were deleted
+		err := snap.Append(&record{
+			RequestType: storage.TypeSetIndex,
+			Index:       i,
+			Version:     module.version,
+		})
+		if err != nil {
+			snap.Close()
+			return err
+		}
+		for d, db := range idx {
+			for e, data := range db.entries {
+				if data.Expired(now) {
+					// Expired entries are dropped, as the log is truncated once the snapshot is written
+					db.delete(e)
+					continue
+				}
+				r := &record{
+					RequestType: storage.TypeSetEntry,
+					Index:       i,
+					DB:          d,
+					Entry:       e,
+					Expires:     data.Expires,
+					Version:     data.Version,
+					Modified:    data.Modified,
+				}
+				err := r.setObject(data.Object)
+				if err == nil {
+					err = snap.Append(r)
+				}
+				if err != nil {
+					snap.Close()
+					return err
+				}
+			}
+		}
+	}
+	if err := snap.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, module.snapshotFile); err != nil {
+		return err
+	}
+
+	// The log is only replaced once the truncated log is open, so a failure leaves the current log in use. Replaying
+	// its records over the new snapshot gives the same data set.
+	wal, err := openLog(module.walFile, 0, module.syncWrites)
+	if err != nil {
+		return err
+	}
+	closeErr := module.wal.Close()
+	module.wal = wal
+	module.walRecords = 0
+	return closeErr
+}
@@ -0,0 +1,78 @@
+package disk
+
+import (
+	"fmt"
+
+	"github.com/jbvmio/modules/storage"
+)
+
+// ErrCode is a numerical code for an error.
+type ErrCode int
+
+// ErrCode Constants
+const (
+	ErrUnknownIndex = 0
+	ErrUnknownDB    = 1
+	ErrUnknownEntry = 2
+	ErrCorruptLog   = 3
+)
+
+// ErrMap contains a map of codes to error string.
+var ErrMap = map[ErrCode]string{
+	ErrUnknownIndex: "unknown index",
+	ErrUnknownDB:    "unknown db",
+	ErrUnknownEntry: "unknown entry",
+	ErrCorruptLog:   "corrupt log record",
+}
+
+// storageErrMap maps codes to the corresponding storage error.
+var storageErrMap = map[ErrCode]error{
+	ErrUnknownIndex: storage.ErrUnknownIndex,
+	ErrUnknownDB:    storage.ErrUnknownDB,
+	ErrUnknownEntry: storage.ErrUnknownEntry,
+}
+
+// Err implements error interface.
+type Err struct {
+	err  string
+	code ErrCode
+}
+
+// Error returns the error string.
+func (e Err) Error() string {
+	return e.err
+}
+
+// Code returns the error code.
+func (e Err) Code() ErrCode {
+	return e.code
+}
+
+// Unwrap returns the storage error corresponding to the ErrCode, so errors.Is can be used with the
+// storage package errors, such as storage.ErrUnknownEntry.
+func (e Err) Unwrap() error {
+	return storageErrMap[e.code]
+}
+
+// GetErr returns the corresponding Err that corresponds to the given ErrCode.
+func GetErr(code ErrCode) Err {
+	return Err{
+		err:  ErrMap[code],
+		code: code,
+	}
+}
+
+// Errf constructs an Storage Err error.
+func Errf(code ErrCode, format string, v ...interface{}) Err {
+	var errMsg string
+	switch {
+	case len(v) > 0:
+		errMsg = ErrMap[code] + `: ` + fmt.Sprintf(format, v...)
+	default:
+		errMsg = ErrMap[code]
+	}
+	return Err{
+		err:  errMsg,
+		code: code,
+	}
+}
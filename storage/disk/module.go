@@ -0,0 +1,289 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/modules/coop"
+	"github.com/jbvmio/modules/storage"
+	"github.com/spf13/viper"
+
+	"go.uber.org/zap"
+)
+
+const (
+	moduleName  = `disk`
+	moduleClass = `disk`
+
+	walFileName      = `storage.wal`
+	snapshotFileName = `storage.snapshot`
+)
+
+// DiskModule is a storage module that persists the data set to local disk. Every TypeSetIndex, TypeSetEntry,
+// TypeDeleteEntry, TypeDeleteDB and TypeDeleteIndex request is appended to a write-ahead log before it is applied,
+// and the full data set is periodically written to a snapshot, after which the log is truncated. On Start, the
+// snapshot and then the log are replayed to restore the data set. All requests are serviced by a single goroutine
+// so that the order of the log always matches the order in which changes were applied.
+//
+// Entries are logged with their version and modification time, so versions keep increasing across restarts. Entries
+// set with a TTL are logged with their expiry time. Expired entries are never returned, and are dropped from
+// the next snapshot. Databases do not expire, so DBTTL is not applied by the disk module.
+type DiskModule struct {
+	// App is a pointer to the application context. This stores the channel to the storage subsystem
+	App *coop.ApplicationContext
+
+	// Log is a logger that has been configured for this module to use. Normally, this means it has been set up with
+	// fields that are appropriate to identify this coordinator
+	Log *zap.Logger
+
+	name             string
+	class            string
+	directory        string
+	snapshotInterval int
+	syncWrites       bool
+	queueDepth       int
+	autoIndex        bool
+
+	walFile      string
+	snapshotFile string
+	wal          *logFile
+	walRecords   int
+
+	requestChannel chan *storage.Request
+	mainRunning    sync.WaitGroup
+	indexes        map[string]index
+
+	// version is the last version assigned to an Entry, restored from the versions of the replayed records
+	version uint64
+
+	quitChannel chan struct{}
+	running     *sync.WaitGroup
+}
+
+// AssignApplicationContext assigns the underlying ApplicationContext.
+func (module *DiskModule) AssignApplicationContext(app *coop.ApplicationContext) {
+	module.App = app
+}
+
+// ModuleDetails returns the Module class and name.
+func (module *DiskModule) ModuleDetails() (string, string) {
+	return moduleClass, moduleName
+}
+
+// AssignModuleLogger assigns the underlying ApplicationContext.
+func (module *DiskModule) AssignModuleLogger(logger *zap.Logger) {
+	module.Log = logger
+}
+
+// ModuleLogger returns the Modules' underlying Logger.
+func (module *DiskModule) ModuleLogger() *zap.Logger {
+	return module.Log
+}
+
+// Init initializes the Module by setting the name, class and
+// assigning the passed in channel and waitgroup.
+func (module *DiskModule) Init(quitChannel chan struct{}, running *sync.WaitGroup) {
+	module.name = moduleName
+	module.class = moduleClass
+	module.quitChannel = quitChannel
+	module.running = running
+}
+
+// Configure validates the configuration for the module, creates a channel to receive requests on, and sets up the
+// storage map. If no directory is set, the data is stored under "data" in the working directory. If no snapshot
+// interval is set, a snapshot is taken every 300 seconds. Writes are not synced to disk individually unless
+// sync-writes is set.
+func (module *DiskModule) Configure() {
+	module.Log.Info("configuring disk module")
+	configRoot := `modules.disk`
+
+	// Set defaults for configs if needed
+	viper.SetDefault(configRoot+".directory", "data")
+	viper.SetDefault(configRoot+".snapshot-interval", 300)
+	viper.SetDefault(configRoot+".sync-writes", false)
+	viper.SetDefault(configRoot+".queue-depth", 1)
+	viper.SetDefault(configRoot+".auto-index", true)
+	module.directory = viper.GetString(configRoot + ".directory")
+	module.snapshotInterval = viper.GetInt(configRoot + ".snapshot-interval")
+	module.syncWrites = viper.GetBool(configRoot + ".sync-writes")
+	module.queueDepth = viper.GetInt(configRoot + ".queue-depth")
+	module.autoIndex = viper.GetBool(configRoot + ".auto-index")
+
+	if module.directory == "" {
+		panic("disk module requires a directory")
+	}
+	if module.snapshotInterval < 1 {
+		panic("disk module snapshot-interval must be at least 1 second")
+	}
+
+	module.walFile = filepath.Join(module.directory, walFileName)
+	module.snapshotFile = filepath.Join(module.directory, snapshotFileName)
+	module.requestChannel = make(chan *storage.Request, module.queueDepth)
+	module.mainRunning = sync.WaitGroup{}
+	module.indexes = make(map[string]index)
+}
+
+// Start restores the data set by replaying the last snapshot followed by the write-ahead log. A torn record at the
+// end of the log, as left by a crash mid-write, is discarded. It then sets up any configured indexes and starts the
+// main loop which services requests.
+func (module *DiskModule) Start() error {
+	module.Log.Info("starting")
+
+	if err := os.MkdirAll(module.directory, 0755); err != nil {
+		module.Log.Error("Error Creating Directory",
+			zap.String("directory", module.directory),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	replay := func(r *record) {
+		if err := module.apply(r); err != nil {
+			module.Log.Debug("Skipping Record",
+				zap.String("request", r.RequestType.String()),
+				zap.Error(err),
+			)
+		}
+	}
+	if _, err := readRecords(module.snapshotFile, replay); err != nil {
+		module.Log.Error("Error Reading Snapshot",
+			zap.String("file", module.snapshotFile),
+			zap.Error(err),
+		)
+		return err
+	}
+	walSize, err := readRecords(module.walFile, func(r *record) {
+		replay(r)
+		module.walRecords++
+	})
+	if err != nil {
+		if _, ok := err.(Err); !ok {
+			module.Log.Error("Error Reading Log",
+				zap.String("file", module.walFile),
+				zap.Error(err),
+			)
+			return err
+		}
+		module.Log.Warn("Discarding Corrupt Log Tail",
+			zap.String("file", module.walFile),
+			zap.Int64("offset", walSize),
+			zap.Error(err),
+		)
+	}
+	module.Log.Info("restored data",
+		zap.Int("indexes", len(module.indexes)),
+		zap.Int("log_records", module.walRecords),
+	)
+
+	module.wal, err = openLog(module.walFile, walSize, module.syncWrites)
+	if err != nil {
+		module.Log.Error("Error Opening Log",
+			zap.String("file", module.walFile),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	for i := range viper.GetStringMap("indexes") {
+		if _, ok := module.indexes[i]; !ok {
+			module.persist(&storage.Request{RequestType: storage.TypeSetIndex, Index: i}, module.Log)
+		}
+	}
+
+	module.mainRunning.Add(1)
+	go module.mainLoop()
+	return nil
+}
+
+// Stop closes the incoming request channel, which will close the main loop. A final snapshot is written and the
+// write-ahead log is closed before returning. If Start failed before the log was opened, the data set was never
+// restored, so no snapshot is written.
+func (module *DiskModule) Stop() error {
+	module.Log.Info("stopping")
+
+	close(module.requestChannel)
+	module.mainRunning.Wait()
+	if module.wal == nil {
+		return nil
+	}
+
+	if err := module.snapshot(); err != nil {
+		module.Log.Error("Error Writing Snapshot",
+			zap.Error(err),
+		)
+	}
+	return module.wal.Close()
+}
+
+func (module *DiskModule) mainLoop() {
+	defer module.mainRunning.Done()
+
+	// Using a map for the request types avoids a bit of complexity below
+	var requestTypeMap = map[storage.RequestConstant]func(*storage.Request, *zap.Logger){
+		storage.TypeSetIndex:       module.addIndex,
+		storage.TypeSetEntry:       module.addEntry,
+		storage.TypeDeleteEntry:    module.deleteEntry,
+		storage.TypeFetchIndexes:   module.fetchIndexList,
+		storage.TypeFetchEntries:   module.fetchEntryList,
+		storage.TypeFetchEntry:     module.fetchEntry,
+		storage.TypeDeleteDB:       module.deleteDB,
+		storage.TypeDeleteIndex:    module.deleteIndex,
+		storage.TypeFetchDatabases: module.fetchDBList,
+		storage.TypeScan:           module.scan,
+		storage.TypeIncrement:      module.increment,
+		storage.TypeDecrement:      module.increment,
+	}
+
+	snapshotTicker := time.NewTicker(time.Duration(module.snapshotInterval) * time.Second)
+	defer snapshotTicker.Stop()
+
+	for {
+		select {
+		case r, ok := <-module.requestChannel:
+			if !ok {
+				return
+			}
+			if err := r.Context().Err(); err != nil && r.Reply != nil {
+				// Requests without a Reply are writes, which are applied once sent as the sender cannot tell otherwise
+				module.Log.Debug("Skipping Cancelled Request",
+					zap.String("request", r.RequestType.String()),
+					zap.Error(err),
+				)
+				close(r.Reply)
+				continue
+			}
+			requestFunc, ok := requestTypeMap[r.RequestType]
+			if !ok {
+				module.Log.Error("unknown storage request type",
+					zap.Int("request_type", int(r.RequestType)),
+				)
+				if r.Reply != nil {
+					close(r.Reply)
+				}
+				continue
+			}
+			requestFunc(r, module.Log.With(
+				zap.String("index", r.Index),
+				zap.String("entry", r.Entry),
+				zap.String("db", r.DB),
+				zap.Int64("timestamp", r.Timestamp),
+				zap.String("request", r.RequestType.String())))
+		case <-snapshotTicker.C:
+			if module.walRecords == 0 {
+				continue
+			}
+			if err := module.snapshot(); err != nil {
+				module.Log.Error("Error Writing Snapshot",
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// GetCommunicationChannel returns the RequestChannel that has been setup for this module.
+func (module *DiskModule) GetCommunicationChannel() chan *storage.Request {
+	return module.requestChannel
+}
@@ -0,0 +1,216 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+)
+
+// record is a single mutation as it is written to the write-ahead log and to snapshots.
+// Records are framed as a 4 byte length, a 4 byte CRC32 checksum and the gob encoded record.
+type record struct {
+	RequestType storage.RequestConstant
+	Index       string
+	DB          string
+	Entry       string
+	Timestamp   int64
+
+	// Type and Encoded hold the Object of a TypeSetEntry record, encoded by the Codec registered for its type.
+	Type    string
+	Encoded []byte
+
+	// Object holds the Object of records written before Objects were encoded by their registered Codec. It is only
+	// read, so such records can still be replayed.
+	Object storage.Object
+
+	// object is the Object the record was created with, so it is not decoded again when the record is applied.
+	object storage.Object
+
+	// Expires is the time, in Unix nanoseconds, after which the Entry of a TypeSetEntry record is expired. Zero
+	// means it never expires.
+	Expires int64
+
+	// Version and Modified are the version and modification time, in Unix nanoseconds, of the Entry of a
+	// TypeSetEntry record. Snapshots also store the last version assigned with each TypeSetIndex record.
+	Version  uint64
+	Modified int64
+}
+
+const frameHeaderSize = 8
+
+// RegisterObject registers the concrete type of the given Object so it can be written to and read from disk. It is
+// registered with a storage.GobCodec under its Go type name, unless a Codec is registered for the type already, and
+// with encoding/gob to read records written before Objects were encoded by their registered Codec. Every Object type
+// stored using the disk module must be registered, with RegisterObject or storage.RegisterCodec, before the module
+// is started.
+func RegisterObject(obj storage.Object) {
+	gob.Register(obj)
+	if !storage.HasCodec(obj) {
+		storage.RegisterGob(reflect.TypeOf(obj).String(), obj)
+	}
+}
+
+func init() {
+	// Counters are stored by TypeIncrement and TypeDecrement requests
+	RegisterObject(storage.Int64(0))
+	RegisterObject(storage.Float64(0))
+}
+
+func newRecord(request *storage.Request) (*record, error) {
+	r := &record{
+		RequestType: request.RequestType,
+		Index:       request.Index,
+		DB:          request.DB,
+		Entry:       request.Entry,
+		Timestamp:   request.Timestamp,
+	}
+	if err := r.setObject(request.Object); err != nil {
+		return nil, err
+	}
+	if request.TTL > 0 {
+		r.Expires = time.Now().Add(request.TTL).UnixNano()
+	}
+	return r, nil
+}
+
+// setObject encodes the Object into the record. A nil Object is stored without a type.
+func (r *record) setObject(obj storage.Object) error {
+	if obj == nil {
+		return nil
+	}
+	var err error
+	r.Type, r.Encoded, err = storage.EncodeObject(obj)
+	r.object = obj
+	return err
+}
+
+// decodeObject returns the Object of the record.
+func (r *record) decodeObject() (storage.Object, error) {
+	switch {
+	case r.object != nil:
+		return r.object, nil
+	case r.Type != "":
+		return storage.DecodeObject(r.Type, r.Encoded)
+	}
+	return r.Object, nil
+}
+
+// encodeRecord returns the framed bytes for a record. Each record uses its own gob encoder so that
+// records can be appended to a file across restarts and decoded independently.
+func encodeRecord(r *record) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(r); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(frame, payload.Bytes()...), nil
+}
+
+// readRecords reads all framed records from the named file and calls apply for each one, in order.
+// It returns the offset of the end of the last valid record. A missing file is not an error.
+// A torn or corrupt record ends the read and is reported with ErrCorruptLog.
+func readRecords(filename string, apply func(*record)) (int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	reader := bufio.NewReader(f)
+	header := make([]byte, frameHeaderSize)
+	for {
+		_, err := io.ReadFull(reader, header)
+		switch {
+		case err == io.EOF:
+			return offset, nil
+		case err != nil:
+			return offset, Errf(ErrCorruptLog, "%v: truncated header at offset %d", filename, offset)
+		}
+		// A corrupt size must not allocate more than the rest of the file could hold
+		size := binary.BigEndian.Uint32(header[0:4])
+		if int64(size) > info.Size()-offset-frameHeaderSize {
+			return offset, Errf(ErrCorruptLog, "%v: record size %d past the end at offset %d", filename, size, offset)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, Errf(ErrCorruptLog, "%v: truncated record at offset %d", filename, offset)
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, Errf(ErrCorruptLog, "%v: checksum mismatch at offset %d", filename, offset)
+		}
+		var r record
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&r); err != nil {
+			return offset, Errf(ErrCorruptLog, "%v: %v", filename, err)
+		}
+		apply(&r)
+		offset += int64(frameHeaderSize + len(payload))
+	}
+}
+
+// logFile is an append only file of framed records.
+type logFile struct {
+	file       *os.File
+	syncWrites bool
+}
+
+// openLog opens the named file for appending, truncating it to the given size first.
+func openLog(filename string, size int64, syncWrites bool) (*logFile, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &logFile{
+		file:       f,
+		syncWrites: syncWrites,
+	}, nil
+}
+
+// Append writes a record to the end of the log.
+func (l *logFile) Append(r *record) error {
+	frame, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(frame); err != nil {
+		return err
+	}
+	if l.syncWrites {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// Close syncs and closes the log.
+func (l *logFile) Close() error {
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
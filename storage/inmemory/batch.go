@@ -0,0 +1,128 @@
+package inmemory
+
+import (
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// batchGroup holds the positions of all operations of a batch targeting the same DB.
+type batchGroup struct {
+	index string
+	db    string
+	ops   []int
+	write bool
+}
+
+func (imm *InMemoryModule) batch(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Applying Batch",
+		zap.Int("operations", len(request.Operations)),
+	)
+
+	// Group the operations by DB, keeping the order of the operations within each group.
+	// Operations on different DBs are independent, so each group can be applied under a single lock acquisition.
+	var groups []*batchGroup
+	groupMap := make(map[[2]string]*batchGroup)
+	results := make([]*storage.OperationResult, len(request.Operations))
+	for i, op := range request.Operations {
+		results[i] = &storage.OperationResult{
+			RequestType: op.RequestType,
+			Index:       op.Index,
+			DB:          op.DB,
+			Entry:       op.Entry,
+		}
+		key := [2]string{op.Index, op.DB}
+		group, ok := groupMap[key]
+		if !ok {
+			group = &batchGroup{index: op.Index, db: op.DB}
+			groupMap[key] = group
+			groups = append(groups, group)
+		}
+		group.ops = append(group.ops, i)
+		if op.RequestType == storage.TypeSetEntry {
+			group.write = true
+		}
+	}
+
+	var events, evicted []*storage.Event
+	for _, group := range groups {
+		now := time.Now().UnixNano()
+		var db *Database
+		var err error
+		if group.write {
+			db, err = imm.getOrCreateDB(request.Operations[group.ops[0]], now, requestLogger)
+		} else {
+			db, err = imm.getDB(group.index, group.db)
+		}
+		if err != nil {
+			for _, i := range group.ops {
+				results[i].Err = err
+			}
+			continue
+		}
+
+		db.Lock()
+		changed, set := len(events), ""
+		for _, i := range group.ops {
+			op := request.Operations[i]
+			if op.DBTTL > 0 {
+				db.SetTTL(op.DBTTL)
+			}
+			switch op.RequestType {
+			case storage.TypeSetEntry:
+				events = append(events, imm.setEntry(db, op, now))
+				set = op.Entry
+			case storage.TypeDeleteEntry:
+				old, err := db.GetEntry(op.Entry)
+				if err == nil && old.Expired(now) {
+					err = Errf(ErrUnknownEntry, "%v", op.Entry)
+				}
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+				db.DeleteEntry(op.Entry)
+				events = append(events, &storage.Event{
+					Type:  storage.EventDelete,
+					Index: op.Index,
+					DB:    op.DB,
+					Entry: op.Entry,
+					Old:   old,
+				})
+			case storage.TypeFetchEntry:
+				data, err := db.GetEntry(op.Entry)
+				if err == nil && data.Expired(now) {
+					err = Errf(ErrUnknownEntry, "%v", op.Entry)
+				}
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+				db.accessed(op.Entry)
+				results[i].Data = data
+			case storage.TypeFetchEntries:
+				entryList := make([]string, 0, len(*db.EntryMap()))
+				for entry, data := range *db.EntryMap() {
+					if !data.Expired(now) {
+						entryList = append(entryList, entry)
+					}
+				}
+				results[i].Entries = entryList
+			}
+		}
+		imm.record(events[changed:]...)
+		evicted = append(evicted, imm.evictDB(db, set)...)
+		db.Unlock()
+		if group.write {
+			evicted = append(evicted, imm.evict(db, set)...)
+		}
+	}
+
+	imm.notify(events...)
+	imm.notifyEvicted(evicted)
+	requestLogger.Debug("ok")
+	request.Respond(&storage.BatchResult{Results: results})
+}
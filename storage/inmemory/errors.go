@@ -1,24 +1,45 @@
 package inmemory
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/jbvmio/modules/storage"
+)
 
 // ErrCode is a numerical code for an error.
 type ErrCode int
 
 // ErrCode Constants
 const (
-	ErrUnknownIndex     = 0
-	ErrUnknownDB        = 1
-	ErrUnknownIndexOrDB = 2
-	ErrUnknownEntry     = 3
+	ErrUnknownIndex          = 0
+	ErrUnknownDB             = 1
+	ErrUnknownIndexOrDB      = 2
+	ErrUnknownEntry          = 3
+	ErrVersionConflict       = 4
+	ErrUnknownSecondaryIndex = 5
+	ErrUnknownSnapshot       = 6
 )
 
 // ErrMap contains a map of codes to error string.
 var ErrMap = map[ErrCode]string{
-	ErrUnknownIndex:     "unknown index",
-	ErrUnknownDB:        "unknown db",
-	ErrUnknownIndexOrDB: "unknown index or db",
-	ErrUnknownEntry:     "unknown entry",
+	ErrUnknownIndex:          "unknown index",
+	ErrUnknownDB:             "unknown db",
+	ErrUnknownIndexOrDB:      "unknown index or db",
+	ErrUnknownEntry:          "unknown entry",
+	ErrVersionConflict:       "version conflict",
+	ErrUnknownSecondaryIndex: "unknown secondary index",
+	ErrUnknownSnapshot:       "unknown snapshot",
+}
+
+// storageErrMap maps codes to the corresponding storage error.
+var storageErrMap = map[ErrCode]error{
+	ErrUnknownIndex:          storage.ErrUnknownIndex,
+	ErrUnknownDB:             storage.ErrUnknownDB,
+	ErrUnknownIndexOrDB:      storage.ErrUnknownDB,
+	ErrUnknownEntry:          storage.ErrUnknownEntry,
+	ErrVersionConflict:       storage.ErrVersionConflict,
+	ErrUnknownSecondaryIndex: storage.ErrUnknownSecondaryIndex,
+	ErrUnknownSnapshot:       storage.ErrUnknownSnapshot,
 }
 
 // Err implements error interface.
@@ -37,6 +58,12 @@ func (e Err) Code() ErrCode {
 	return e.code
 }
 
+// Unwrap returns the storage error corresponding to the ErrCode, so errors.Is can be used with the
+// storage package errors, such as storage.ErrUnknownEntry.
+func (e Err) Unwrap() error {
+	return storageErrMap[e.code]
+}
+
 // GetErr returns the corresponding Err that corresponds to the given ErrCode.
 func GetErr(code ErrCode) Err {
 	return Err{
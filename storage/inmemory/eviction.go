@@ -0,0 +1,242 @@
+package inmemory
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/jbvmio/modules/storage"
+)
+
+// Limiter tracks the entries of every Database of a module to enforce its memory limits. A Limiter can be shared by
+// several modules with SetLimiter, so the limits apply to the entries of all of them together.
+type Limiter struct {
+	limits storage.Limits
+
+	// lock protects the Evictor of the module and the Evictor of every Database.
+	lock    sync.Mutex
+	entries *storage.Evictor[entryKey]
+	evicted uint64
+}
+
+// entryKey identifies an Entry across all Databases.
+type entryKey struct {
+	db    *Database
+	entry string
+}
+
+// NewLimiter returns a Limiter enforcing the limits.
+func NewLimiter(limits storage.Limits) *Limiter {
+	l := &Limiter{
+		limits: limits,
+	}
+	if limits.Global() {
+		l.entries = storage.NewEvictor[entryKey](limits.Policy)
+	}
+	return l
+}
+
+// track starts tracking the entries of the Database under the memory limits of the module, with the names the
+// Database is stored under. The Database must not be locked by the caller.
+func (db *Database) track(imm *InMemoryModule, index, name string) {
+	l := imm.limiter
+	if l == nil {
+		return
+	}
+	db.Lock()
+	defer db.Unlock()
+	db.limiter = l
+	db.module = imm
+	db.index = index
+	db.name = name
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.limits.MaxDBEntries > 0 {
+		db.evictor = storage.NewEvictor[string](l.limits.Policy)
+	}
+	for entry, data := range db.entries {
+		l.add(db, entry, data)
+	}
+}
+
+// untrack stops tracking the entries of the Database, once it is removed from its Index. The Database must not be
+// locked by the caller.
+func (db *Database) untrack() {
+	db.Lock()
+	defer db.Unlock()
+	l := db.limiter
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.entries != nil {
+		for entry := range db.entries {
+			l.entries.Remove(entryKey{db: db, entry: entry})
+		}
+	}
+	db.limiter = nil
+	db.module = nil
+	db.evictor = nil
+}
+
+// add tracks an Entry which was added or replaced. The lock must be held by the caller.
+func (l *Limiter) add(db *Database, entry string, data *storage.Data) {
+	size := storage.EntrySize(entry, data.Object)
+	if db.evictor != nil {
+		db.evictor.Add(entry, size)
+	}
+	if l.entries != nil {
+		l.entries.Add(entryKey{db: db, entry: entry}, size)
+	}
+}
+
+// added tracks an Entry added to the Database. The Database must be locked by the caller.
+func (db *Database) added(entry string, data *storage.Data) {
+	if db.limiter == nil {
+		return
+	}
+	db.limiter.lock.Lock()
+	db.limiter.add(db, entry, data)
+	db.limiter.lock.Unlock()
+}
+
+// removed stops tracking an Entry removed from the Database. The Database must be locked by the caller.
+func (db *Database) removed(entry string) {
+	l := db.limiter
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if db.evictor != nil {
+		db.evictor.Remove(entry)
+	}
+	if l.entries != nil {
+		l.entries.Remove(entryKey{db: db, entry: entry})
+	}
+}
+
+// accessed records a fetch of the Entry, for the LRU and LFU eviction policies. The Database must be locked by the
+// caller.
+func (db *Database) accessed(entry string) {
+	l := db.limiter
+	if l == nil || l.limits.Policy == storage.EvictFIFO {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if db.evictor != nil {
+		db.evictor.Touch(entry)
+	}
+	if l.entries != nil {
+		l.entries.Touch(entryKey{db: db, entry: entry})
+	}
+}
+
+// evictDB evicts entries of the Database over the max-db-entries limit, other than the Entry just set, and returns
+// the evictions. The Database must be locked by the caller.
+func (imm *InMemoryModule) evictDB(db *Database, set string) []*storage.Event {
+	l := db.limiter
+	if l == nil || l.limits.MaxDBEntries == 0 {
+		return nil
+	}
+	var evicted []*storage.Event
+	for {
+		l.lock.Lock()
+		entry, ok := "", db.evictor.Len() > l.limits.MaxDBEntries
+		if ok {
+			entry, ok = db.evictor.Victim(set)
+		}
+		l.lock.Unlock()
+		if !ok {
+			return evicted
+		}
+		evicted = append(evicted, imm.evictEntry(db, entry))
+	}
+}
+
+// evict evicts entries across all Databases over the max-entries and max-bytes limits, other than the Entry just set
+// in the Database, and returns the evictions. The Database must not be locked by the caller, as the entries evicted
+// can be in any Database. Entries evicted from the Databases of another module sharing the Limiter are sent to the
+// watches of that module instead of being returned.
+func (imm *InMemoryModule) evict(db *Database, set string) []*storage.Event {
+	l := imm.limiter
+	if l == nil || l.entries == nil {
+		return nil
+	}
+	var evicted []*storage.Event
+	for {
+		l.lock.Lock()
+		var victim entryKey
+		ok := l.limits.Over(l.entries.Len(), l.entries.Bytes())
+		if ok {
+			victim, ok = l.entries.Victim(entryKey{db: db, entry: set})
+		}
+		l.lock.Unlock()
+		if !ok {
+			return evicted
+		}
+
+		victim.db.Lock()
+		if _, err := victim.db.GetEntry(victim.entry); err != nil || victim.db.limiter != l {
+			// Removed since it was chosen
+			l.lock.Lock()
+			l.entries.Remove(victim)
+			l.lock.Unlock()
+			victim.db.Unlock()
+			continue
+		}
+		owner := victim.db.module
+		event := owner.evictEntry(victim.db, victim.entry)
+		victim.db.Unlock()
+		if owner != imm {
+			owner.notifyEvicted([]*storage.Event{event})
+			continue
+		}
+		evicted = append(evicted, event)
+	}
+}
+
+// evictEntry removes the Entry from the Database and records the eviction. The Database must be locked by the caller.
+func (imm *InMemoryModule) evictEntry(db *Database, entry string) *storage.Event {
+	old, _ := db.GetEntry(entry)
+	db.DeleteEntry(entry)
+	atomic.AddUint64(&imm.limiter.evicted, 1)
+	event := &storage.Event{
+		Type:  storage.EventEvict,
+		Index: db.index,
+		DB:    db.name,
+		Entry: entry,
+		Old:   old,
+	}
+	imm.record(event)
+	return event
+}
+
+// notifyEvicted sends the evictions to watches, if the module is configured to send eviction events.
+func (imm *InMemoryModule) notifyEvicted(evicted []*storage.Event) {
+	if imm.evictEvents {
+		imm.notify(evicted...)
+	}
+}
+
+// Limiter returns the Limiter enforcing the memory limits of the module, or nil if no limit is set.
+func (module *InMemoryModule) Limiter() *Limiter {
+	return module.limiter
+}
+
+// SetLimiter sets the Limiter enforcing the memory limits of the module, such as the Limiter of another module, so
+// the limits apply to the entries of both. It must be set after the module is configured and before it is started.
+// A nil Limiter removes the limits.
+func (module *InMemoryModule) SetLimiter(l *Limiter) {
+	module.limiter = l
+}
+
+// Evicted returns the number of entries evicted by the memory limits of the module, including those of any other
+// module sharing its Limiter.
+func (module *InMemoryModule) Evicted() uint64 {
+	if module.limiter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&module.limiter.evicted)
+}
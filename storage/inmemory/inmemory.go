@@ -1,6 +1,8 @@
 package inmemory
 
 import (
+	"time"
+
 	"github.com/jbvmio/modules/storage"
 
 	"go.uber.org/zap"
@@ -9,30 +11,122 @@ import (
 func (imm *InMemoryModule) requestWorker(workerNum int, requestChannel chan *storage.Request) {
 	defer imm.workersRunning.Done()
 
-	// Using a map for the request types avoids a bit of complexity below
-	var requestTypeMap = map[storage.RequestConstant]func(*storage.Request, *zap.Logger){
-		storage.TypeSetIndex:     imm.addIndex,
-		storage.TypeSetEntry:     imm.addEntry,
-		storage.TypeDeleteEntry:  imm.deleteEntry,
-		storage.TypeFetchEntries: imm.fetchEntryList,
-		storage.TypeFetchEntry:   imm.fetchEntry,
-	}
-
 	workerLogger := imm.Log.With(zap.Int("worker", workerNum))
 	for r := range requestChannel {
-		if requestFunc, ok := requestTypeMap[r.RequestType]; ok {
-			requestFunc(r, workerLogger.With(
-				zap.String("index", r.Index),
-				zap.String("entry", r.Entry),
-				zap.String("db", r.DB),
-				zap.Int64("timestamp", r.Timestamp),
-				zap.String("request", r.RequestType.String())))
+		if r.RequestType == typeHold {
+			// Signal the worker is held, then wait for the held request to be handled
+			close(r.Reply)
+			<-r.Done
+			continue
 		}
+		imm.handle(r, workerLogger)
+	}
+}
+
+// requestTypes returns the function servicing each request type.
+func (imm *InMemoryModule) requestTypes() map[storage.RequestConstant]func(*storage.Request, *zap.Logger) {
+	return map[storage.RequestConstant]func(*storage.Request, *zap.Logger){
+		storage.TypeSetIndex:          imm.addIndex,
+		storage.TypeSetEntry:          imm.addEntry,
+		storage.TypeDeleteEntry:       imm.deleteEntry,
+		storage.TypeFetchIndexes:      imm.fetchIndexList,
+		storage.TypeFetchEntries:      imm.fetchEntryList,
+		storage.TypeFetchEntry:        imm.fetchEntry,
+		storage.TypeWatch:             imm.addWatch,
+		storage.TypeBatch:             imm.batch,
+		storage.TypeCompareAndSet:     imm.compareAndSet,
+		storage.TypeDeleteDB:          imm.deleteDB,
+		storage.TypeDeleteIndex:       imm.deleteIndex,
+		storage.TypeFetchDatabases:    imm.fetchDBList,
+		storage.TypeScan:              imm.scan,
+		storage.TypeSetSecondaryIndex: imm.setSecondaryIndex,
+		storage.TypeFetchByIndex:      imm.fetchByIndex,
+		storage.TypeIncrement:         imm.increment,
+		storage.TypeDecrement:         imm.increment,
+		storage.TypeTransaction:       imm.transaction,
+		storage.TypeAppendEntry:       imm.appendEntry,
+		storage.TypeSnapshot:          imm.createSnapshot,
+		storage.TypeReleaseSnapshot:   imm.releaseSnapshot,
+	}
+}
+
+// snapshotReads holds the request types which read from the snapshot view if their Snapshot field is set.
+var snapshotReads = map[storage.RequestConstant]bool{
+	storage.TypeFetchIndexes:   true,
+	storage.TypeFetchDatabases: true,
+	storage.TypeFetchEntries:   true,
+	storage.TypeFetchEntry:     true,
+	storage.TypeScan:           true,
+}
+
+// handle services a single request, unless its context is done.
+func (imm *InMemoryModule) handle(r *storage.Request, logger *zap.Logger) {
+	if err := r.Context().Err(); err != nil && r.Reply != nil {
+		// Requests without a Reply are writes, which are applied once sent as the sender cannot tell otherwise
+		logger.Debug("Skipping Cancelled Request",
+			zap.String("request", r.RequestType.String()),
+			zap.Error(err),
+		)
+		close(r.Reply)
+		return
+	}
+	requestFunc, ok := imm.handlers[r.RequestType]
+	if r.Snapshot != "" && r.RequestType != storage.TypeReleaseSnapshot {
+		if !snapshotReads[r.RequestType] {
+			err := storage.Errorf(storage.CodeInvalidRequest, "Snapshot is not allowed for %v", r.RequestType)
+			logger.Error("Error Handling Request",
+				zap.String("request", r.RequestType.String()),
+				zap.Error(err),
+			)
+			storage.Reject(r, err)
+			return
+		}
+		// Fetch requests for a snapshot all read from the snapshot view
+		requestFunc = imm.fetchSnapshot
+	}
+	if ok {
+		requestFunc(r, logger.With(
+			zap.String("index", r.Index),
+			zap.String("entry", r.Entry),
+			zap.String("db", r.DB),
+			zap.Int64("timestamp", r.Timestamp),
+			zap.String("request", r.RequestType.String())))
+	}
+}
+
+// getIndex returns the specified Index or error if not found.
+func (imm *InMemoryModule) getIndex(index string) (*Index, error) {
+	imm.indexLock.RLock()
+	i, ok := imm.indexes[index]
+	imm.indexLock.RUnlock()
+	if !ok {
+		return nil, Errf(ErrUnknownIndex, "%v", index)
 	}
+	return i, nil
+}
+
+// getDB returns the specified Database, recording the access, or error if not found or expired.
+func (imm *InMemoryModule) getDB(index, db string) (*Database, error) {
+	i, err := imm.getIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano()
+	i.RLock()
+	defer i.RUnlock()
+	database, err := i.GetDB(db)
+	if err != nil {
+		return nil, err
+	}
+	if database.Expired(now) {
+		return nil, Errf(ErrUnknownDB, "%v", db)
+	}
+	database.Touch(now)
+	return database, nil
 }
 
 func (imm *InMemoryModule) deleteEntry(request *storage.Request, requestLogger *zap.Logger) {
-	db, err := imm.indexes[request.Index].GetDB(request.DB)
+	db, err := imm.getDB(request.Index, request.DB)
 	if err != nil {
 		requestLogger.Error("Error Retrieving Database",
 			zap.Error(err),
@@ -40,7 +134,7 @@ func (imm *InMemoryModule) deleteEntry(request *storage.Request, requestLogger *
 		return
 	}
 	db.Lock()
-	_, err = db.GetEntry(request.Entry)
+	old, err := db.GetEntry(request.Entry)
 	if err != nil {
 		requestLogger.Error("Error Retrieving Entry",
 			zap.Error(err),
@@ -51,7 +145,18 @@ func (imm *InMemoryModule) deleteEntry(request *storage.Request, requestLogger *
 
 	//delete(*db.EntryMap(), request.Entry)
 	db.DeleteEntry(request.Entry)
+	event := &storage.Event{
+		Type:  storage.EventDelete,
+		Index: request.Index,
+		DB:    request.DB,
+		Entry: request.Entry,
+		Old:   old,
+	}
+	imm.record(event)
 	db.Unlock()
+	if !old.Expired(time.Now().UnixNano()) {
+		imm.notify(event)
+	}
 	requestLogger.Debug("ok")
 }
 
@@ -59,54 +164,100 @@ func (imm *InMemoryModule) fetchEntryList(request *storage.Request, requestLogge
 	defer close(request.Reply)
 	requestLogger.Debug("Fetching Entries")
 
-	db, err := imm.indexes[request.Index].GetDB(request.DB)
+	db, err := imm.getDB(request.Index, request.DB)
 	if err != nil {
 		requestLogger.Error("Error Retrieving Database",
 			zap.Error(err),
 		)
+		request.Respond(err)
 		return
 	}
 
+	now := time.Now().UnixNano()
 	db.RLock()
 	entries := *db.EntryMap()
 	entryList := make([]string, 0, len(entries))
-	for entry := range entries {
-		entryList = append(entryList, entry)
+	for entry, data := range entries {
+		if !data.Expired(now) {
+			entryList = append(entryList, entry)
+		}
 	}
 	db.RUnlock()
 
 	requestLogger.Debug("ok")
-	request.Reply <- entryList
+	request.Respond(entryList)
 }
 
 func (imm *InMemoryModule) fetchEntry(request *storage.Request, requestLogger *zap.Logger) {
 	defer close(request.Reply)
 	requestLogger.Debug("Fetching Entry")
 
-	db, err := imm.indexes[request.Index].GetDB(request.DB)
+	db, err := imm.getDB(request.Index, request.DB)
 	if err != nil {
 		requestLogger.Error("Error Retrieving Database",
 			zap.Error(err),
 		)
+		request.Respond(err)
 		return
 	}
 
 	db.RLock()
 	data, err := db.GetEntry(request.Entry)
+	if err == nil && data.Expired(time.Now().UnixNano()) {
+		err = Errf(ErrUnknownEntry, "%v", request.Entry)
+	}
 	if err != nil {
 		requestLogger.Error("Error Retrieving Entry",
 			zap.Error(err),
 		)
 		db.RUnlock()
+		request.Respond(err)
 		return
 	}
+	db.accessed(request.Entry)
+	db.RUnlock()
+
+	requestLogger.Debug("ok")
+	request.Respond(data)
+}
+
+// scan replies with a page of entries in sorted key order. The Database is only locked while the page is collected,
+// so other requests are serviced in between pages.
+func (imm *InMemoryModule) scan(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Scanning Entries")
+
+	db, err := imm.getDB(request.Index, request.DB)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+
+	now := time.Now().UnixNano()
+	db.RLock()
+	result, err := request.Scan.Page(db.SortedKeys(), func(entry string) (*storage.Data, bool) {
+		data, err := db.GetEntry(entry)
+		return data, err == nil && !data.Expired(now)
+	})
 	db.RUnlock()
+	if err != nil {
+		requestLogger.Error("Error Scanning Entries",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
 
 	requestLogger.Debug("ok")
-	request.Reply <- data
+	request.Respond(result)
 }
 
 func (imm *InMemoryModule) addIndex(request *storage.Request, requestLogger *zap.Logger) {
+	imm.indexLock.Lock()
+	defer imm.indexLock.Unlock()
 	_, ok := imm.indexes[request.Index]
 	if ok {
 		requestLogger.Warn("Index Exists")
@@ -114,57 +265,409 @@ func (imm *InMemoryModule) addIndex(request *storage.Request, requestLogger *zap
 	}
 	requestLogger.Debug("Adding Index")
 	imm.indexes[request.Index] = NewIndex()
+	imm.record(&storage.Event{
+		Type:  storage.EventSetIndex,
+		Index: request.Index,
+	})
 	return
 }
 
 func (imm *InMemoryModule) addEntry(request *storage.Request, requestLogger *zap.Logger) {
-	index, ok := imm.indexes[request.Index]
-	if !ok {
+	now := time.Now().UnixNano()
+	db, err := imm.getOrCreateDB(request, now, requestLogger)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		return
+	}
+	requestLogger.Debug("Adding Data")
+
+	db.Lock()
+	event := imm.setEntry(db, request, now)
+	imm.record(event)
+	evicted := imm.evictDB(db, request.Entry)
+	db.Unlock()
+	evicted = append(evicted, imm.evict(db, request.Entry)...)
+
+	imm.notify(event)
+	imm.notifyEvicted(evicted)
+	requestLogger.Debug("ok")
+	return
+}
+
+// getOrCreateDB returns the Database for a TypeSetEntry request, creating the Index and Database as needed.
+// An expired Database is replaced with a new one.
+func (imm *InMemoryModule) getOrCreateDB(request *storage.Request, now int64, requestLogger *zap.Logger) (*Database, error) {
+	index, err := imm.getIndex(request.Index)
+	if err != nil {
 		if !imm.autoIndex {
-			requestLogger.Error("unknown index",
-				zap.String("index", request.Index),
-			)
-			return
+			return nil, err
 		}
 		requestLogger.Debug("Auto-Adding Index")
 		imm.addIndex(request, requestLogger)
-		index = imm.indexes[request.Index]
+		index, _ = imm.getIndex(request.Index)
 	}
-	requestLogger.Debug("Adding Data")
 
 	index.Lock()
+	defer index.Unlock()
 	db, err := index.GetDB(request.DB)
+	switch {
+	case err == nil && db.Expired(now):
+		requestLogger.Debug("Replacing Expired Database")
+		imm.record(&storage.Event{
+			Type:  storage.EventDeleteDB,
+			Index: request.Index,
+			DB:    request.DB,
+		})
+		fallthrough
+	case err != nil && err.(Err).Code() == ErrUnknownDB:
+		requestLogger.Debug("Creating New Database")
+		db = NewDatabase()
+		db.SetTTL(time.Duration(imm.expireGroup) * time.Second)
+		db.track(imm, request.Index, request.DB)
+		index.AddDB(request.DB, db)
+	case err != nil:
+		return nil, err
+	}
+	if request.DBTTL > 0 {
+		db.SetTTL(request.DBTTL)
+	}
+	// Touch the Database while holding the Index lock so it cannot be reaped before the entry is added.
+	db.Touch(now)
+	return db, nil
+}
+
+// setEntry adds the Entry of a TypeSetEntry request to the Database and returns the resulting Event.
+// The Database must be locked by the caller.
+func (imm *InMemoryModule) setEntry(db *Database, request *storage.Request, now int64) *storage.Event {
+	data := &storage.Data{
+		Object:   request.Object,
+		Version:  imm.versions.Add(1),
+		Modified: now,
+	}
+	if request.TTL > 0 {
+		data.Expires = now + int64(request.TTL)
+	}
+	old, err := db.GetEntry(request.Entry)
+	if err != nil || old.Expired(now) {
+		old = nil
+	}
+	db.AddEntry(request.Entry, data)
+	return &storage.Event{
+		Type:  storage.EventSet,
+		Index: request.Index,
+		DB:    request.DB,
+		Entry: request.Entry,
+		Old:   old,
+		New:   data,
+	}
+}
+
+func (imm *InMemoryModule) compareAndSet(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Comparing And Setting Data",
+		zap.Uint64("version", request.Version),
+	)
+
+	now := time.Now().UnixNano()
+	var db *Database
+	var err error
+	if request.Version == 0 {
+		db, err = imm.getOrCreateDB(request, now, requestLogger)
+	} else if db, err = imm.getDB(request.Index, request.DB); err != nil {
+		// The Entry cannot exist, so it cannot be at the expected version
+		err = Errf(ErrVersionConflict, "%v: expected version %d, found %d", request.Entry, request.Version, 0)
+	}
 	if err != nil {
-		if err.(Err).Code() == ErrUnknownDB {
-			requestLogger.Debug("Creating New Database")
-			db = NewDatabase()
-			index.AddDB(request.DB, db)
-		} else {
-			requestLogger.Error("Error Retrieving Database",
-				zap.Error(err),
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+
+	db.Lock()
+	var current uint64
+	if data, err := db.GetEntry(request.Entry); err == nil && !data.Expired(now) {
+		current = data.Version
+	}
+	if current != request.Version {
+		db.Unlock()
+		err := Errf(ErrVersionConflict, "%v: expected version %d, found %d", request.Entry, request.Version, current)
+		requestLogger.Debug("Version Conflict",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	event := imm.setEntry(db, request, now)
+	imm.record(event)
+	evicted := imm.evictDB(db, request.Entry)
+	db.Unlock()
+	evicted = append(evicted, imm.evict(db, request.Entry)...)
+
+	imm.notify(event)
+	imm.notifyEvicted(evicted)
+	requestLogger.Debug("ok")
+	request.Respond(event.New)
+}
+
+// increment services both TypeIncrement and TypeDecrement requests. The counter is read and stored under the
+// Database lock, so concurrent requests for the same Entry never lose an update.
+func (imm *InMemoryModule) increment(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Updating Counter")
+
+	now := time.Now().UnixNano()
+	db, err := imm.getOrCreateDB(request, now, requestLogger)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+
+	db.Lock()
+	var current storage.Object
+	if data, err := db.GetEntry(request.Entry); err == nil && !data.Expired(now) {
+		current = data.Object
+	}
+	value, err := storage.ApplyDelta(current, request)
+	if err != nil {
+		db.Unlock()
+		requestLogger.Error("Error Updating Counter",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	set := *request
+	set.Object = value
+	event := imm.setEntry(db, &set, now)
+	imm.record(event)
+	evicted := imm.evictDB(db, request.Entry)
+	db.Unlock()
+	evicted = append(evicted, imm.evict(db, request.Entry)...)
+
+	imm.notify(event)
+	imm.notifyEvicted(evicted)
+	requestLogger.Debug("ok")
+	request.Respond(event.New)
+}
+
+// appendEntry pushes the Object of a TypeAppendEntry request into the Ring stored in the Entry, keeping the last
+// intervals values. Values less than min-distance seconds after the newest value are dropped.
+func (imm *InMemoryModule) appendEntry(request *storage.Request, requestLogger *zap.Logger) {
+	now := time.Now().UnixNano()
+	db, err := imm.getOrCreateDB(request, now, requestLogger)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		return
+	}
+	requestLogger.Debug("Appending Data")
+
+	point := storage.RingPoint{
+		Timestamp: request.Timestamp,
+		Object:    request.Object,
+	}
+	if point.Timestamp == 0 {
+		point.Timestamp = now
+	}
+
+	db.Lock()
+	var ring *storage.Ring
+	if data, err := db.GetEntry(request.Entry); err == nil && !data.Expired(now) {
+		var ok bool
+		if ring, ok = data.Object.(*storage.Ring); !ok {
+			db.Unlock()
+			requestLogger.Error("Error Appending Data",
+				zap.Error(storage.Errorf(storage.CodeInvalidType, "%v: %T is not a *Ring", request.Entry, data.Object)),
 			)
-			index.Unlock()
 			return
 		}
 	}
+	ring, ok := ring.Append(point, imm.intervals, imm.minDistance*int64(time.Second))
+	if !ok {
+		db.Unlock()
+		requestLogger.Debug("Dropping Data Within Min Distance")
+		return
+	}
+	set := *request
+	set.Object = ring
+	event := imm.setEntry(db, &set, now)
+	imm.record(event)
+	evicted := imm.evictDB(db, request.Entry)
+	db.Unlock()
+	evicted = append(evicted, imm.evict(db, request.Entry)...)
 
-	index.Unlock()
-	db.Lock()
-	defer db.Unlock()
-	db.AddEntry(request.Entry, &storage.Data{request.Object})
-
+	imm.notify(event)
+	imm.notifyEvicted(evicted)
 	requestLogger.Debug("ok")
-	return
 }
 
 func (imm *InMemoryModule) fetchIndexList(request *storage.Request, requestLogger *zap.Logger) {
 	defer close(request.Reply)
 	requestLogger.Debug("Fetching Indexes")
 
+	imm.indexLock.RLock()
 	indexList := make([]string, 0, len(imm.indexes))
 	for i := range imm.indexes {
 		indexList = append(indexList, i)
 	}
+	imm.indexLock.RUnlock()
+	requestLogger.Debug("ok")
+	request.Respond(indexList)
+}
+
+// reap removes all expired Databases, expired entries and expired snapshots.
+func (imm *InMemoryModule) reap() {
+	now := time.Now().UnixNano()
+	imm.indexLock.RLock()
+	indexes := make(map[string]*Index, len(imm.indexes))
+	for name, index := range imm.indexes {
+		indexes[name] = index
+	}
+	imm.indexLock.RUnlock()
+
+	var reapedDBs, reapedEntries int
+	for name, index := range indexes {
+		var events []*storage.Event
+		index.Lock()
+		for dbName, db := range *index.DBMap() {
+			dbExpired := db.Expired(now)
+			if dbExpired {
+				index.DeleteDB(dbName)
+				reapedDBs++
+			}
+			db.Lock()
+			expired := len(events)
+			for entry, data := range *db.EntryMap() {
+				if dbExpired || data.Expired(now) {
+					if !dbExpired {
+						db.DeleteEntry(entry)
+						reapedEntries++
+					}
+					events = append(events, &storage.Event{
+						Type:  storage.EventExpire,
+						Index: name,
+						DB:    dbName,
+						Entry: entry,
+						Old:   data,
+					})
+				}
+			}
+			if dbExpired {
+				imm.record(&storage.Event{
+					Type:  storage.EventDeleteDB,
+					Index: name,
+					DB:    dbName,
+				})
+			} else {
+				imm.record(events[expired:]...)
+			}
+			db.Unlock()
+		}
+		index.Unlock()
+		imm.notify(events...)
+	}
+	for _, snap := range imm.snapshots.expired(now) {
+		snap.release()
+	}
+	if reapedDBs > 0 || reapedEntries > 0 {
+		imm.Log.Debug("reaped expired data",
+			zap.Int("databases", reapedDBs),
+			zap.Int("entries", reapedEntries),
+		)
+	}
+}
+
+func (imm *InMemoryModule) fetchDBList(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Fetching Databases")
+
+	index, err := imm.getIndex(request.Index)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+
+	now := time.Now().UnixNano()
+	index.RLock()
+	dbs := *index.DBMap()
+	dbList := make([]string, 0, len(dbs))
+	for name, db := range dbs {
+		if !db.Expired(now) {
+			dbList = append(dbList, name)
+		}
+	}
+	index.RUnlock()
+
+	requestLogger.Debug("ok")
+	request.Respond(dbList)
+}
+
+// deleteDB removes the Database from its Index. Once any in-flight requests holding it have completed, nothing
+// references the Database or its entries and the memory is released.
+func (imm *InMemoryModule) deleteDB(request *storage.Request, requestLogger *zap.Logger) {
+	index, err := imm.getIndex(request.Index)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(err),
+		)
+		return
+	}
+	requestLogger.Debug("Deleting Database")
+
+	index.Lock()
+	if _, err := index.GetDB(request.DB); err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		index.Unlock()
+		return
+	}
+	index.DeleteDB(request.DB)
+	event := &storage.Event{
+		Type:  storage.EventDeleteDB,
+		Index: request.Index,
+		DB:    request.DB,
+	}
+	imm.record(event)
+	index.Unlock()
+
+	imm.notify(event)
+	requestLogger.Debug("ok")
+}
+
+// deleteIndex removes the Index along with all of its Databases. As with deleteDB, the memory is released once any
+// in-flight requests holding the Index have completed.
+func (imm *InMemoryModule) deleteIndex(request *storage.Request, requestLogger *zap.Logger) {
+	requestLogger.Debug("Deleting Index")
+	imm.indexLock.Lock()
+	if _, ok := imm.indexes[request.Index]; !ok {
+		imm.indexLock.Unlock()
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(Errf(ErrUnknownIndex, "%v", request.Index)),
+		)
+		return
+	}
+	index := imm.indexes[request.Index]
+	delete(imm.indexes, request.Index)
+	index.untrack()
+	event := &storage.Event{
+		Type:  storage.EventDeleteIndex,
+		Index: request.Index,
+	}
+	imm.record(event)
+	imm.indexLock.Unlock()
+
+	imm.notify(event)
 	requestLogger.Debug("ok")
-	request.Reply <- indexList
 }
@@ -1,16 +1,23 @@
 package inmemory
 
 import (
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jbvmio/modules/storage"
 )
 
 // Index contains a map of Databases.
 type Index struct {
-	//idx map[string][]*ring.Ring // Future Feature*
 	db map[string]*Database
 
+	// secondary holds the secondary indexes declared for each Database by name. They are kept by the Index, so they
+	// are applied again when a Database is replaced or deleted and created again.
+	secondary map[string]map[string]storage.IndexFunc
+
 	// This lock is used when modifying indexes.
 	idxLock *sync.RWMutex
 }
@@ -20,12 +27,35 @@ type Database struct {
 	lock       *sync.RWMutex
 	entries    map[string]*storage.Data
 	lastAccess int64
+	ttl        int64
+
+	// keys caches the sorted entry keys for scans. It is built on the first scan and kept sorted as keys are added
+	// or removed. keysShared is set once a snapshot shares the slice, which is then copied before it is changed.
+	keysLock   sync.Mutex
+	keys       []string
+	keysShared bool
+
+	// secondary holds the secondary indexes of the Database by name, maintained by AddEntry and DeleteEntry.
+	secondary map[string]*secondaryIndex
+
+	// shares is the number of snapshots sharing the entries map, which is copied before the next write. gen is
+	// incremented on every copy, so a released snapshot only releases the entries map it shared.
+	shares int
+	gen    uint64
+
+	// limiter tracks the entries of the Database under the memory limits of the module, with evictor ordering them
+	// for the max-db-entries limit. module is the module holding the Database, and index and name are the names the
+	// Database is stored under, for evictions.
+	limiter *Limiter
+	module  *InMemoryModule
+	evictor *storage.Evictor[string]
+	index   string
+	name    string
 }
 
 // NewIndex returns a new Index.
 func NewIndex() *Index {
 	return &Index{
-		//idx:     make(map[string][]*ring.Ring),
 		db:      make(map[string]*Database),
 		idxLock: &sync.RWMutex{},
 	}
@@ -40,9 +70,45 @@ func (i *Index) GetDB(db string) (*Database, error) {
 	return database, nil
 }
 
-// AddDB add an existing Database to Index DatabaseMap.
+// AddDB add an existing Database to Index DatabaseMap, and applies the secondary indexes declared for it which the
+// Database does not have. Any Database it replaces is no longer tracked under the memory limits of the module.
 func (i *Index) AddDB(db string, database *Database) {
+	if old, ok := i.db[db]; ok && old != database {
+		old.untrack()
+	}
 	i.db[db] = database
+	if len(i.secondary[db]) > 0 {
+		database.Lock()
+		for name, extract := range i.secondary[db] {
+			if _, ok := database.secondary[name]; !ok {
+				database.SetSecondaryIndex(name, extract)
+			}
+		}
+		database.Unlock()
+	}
+}
+
+// DeleteDB deletes the specified Database from the Index, which is then no longer tracked under the memory limits
+// of the module.
+func (i *Index) DeleteDB(db string) {
+	if database, ok := i.db[db]; ok {
+		database.untrack()
+	}
+	delete(i.db, db)
+}
+
+// DBMap returns the specified underlying DatabaseMap for the Index.
+func (i *Index) DBMap() *map[string]*Database {
+	return &i.db
+}
+
+// untrack stops tracking every Database of the Index, once the Index is removed.
+func (i *Index) untrack() {
+	i.Lock()
+	defer i.Unlock()
+	for _, database := range i.db {
+		database.untrack()
+	}
 }
 
 // Lock locks the Index.
@@ -55,14 +121,41 @@ func (i *Index) Unlock() {
 	i.idxLock.Unlock()
 }
 
+// RLock puts a Read Lock on the Index.
+func (i *Index) RLock() {
+	i.idxLock.RLock()
+}
+
+// RUnlock removes a Read Lock the Index.
+func (i *Index) RUnlock() {
+	i.idxLock.RUnlock()
+}
+
 // NewDatabase returns a new Database.
 func NewDatabase() *Database {
 	return &Database{
-		lock:    &sync.RWMutex{},
-		entries: make(map[string]*storage.Data),
+		lock:       &sync.RWMutex{},
+		entries:    make(map[string]*storage.Data),
+		lastAccess: time.Now().UnixNano(),
 	}
 }
 
+// SetTTL sets the time the Database is kept without being accessed. Zero means the Database does not expire.
+func (db *Database) SetTTL(ttl time.Duration) {
+	atomic.StoreInt64(&db.ttl, int64(ttl))
+}
+
+// Touch records an access of the Database at the given time, in Unix nanoseconds.
+func (db *Database) Touch(now int64) {
+	atomic.StoreInt64(&db.lastAccess, now)
+}
+
+// Expired returns true if the Database has not been accessed within its TTL at the given time, in Unix nanoseconds.
+func (db *Database) Expired(now int64) bool {
+	ttl := atomic.LoadInt64(&db.ttl)
+	return ttl > 0 && now-atomic.LoadInt64(&db.lastAccess) >= ttl
+}
+
 // GetEntry returns the specified Entry from the Database.
 func (db *Database) GetEntry(entry string) (*storage.Data, error) {
 	data, ok := db.entries[entry]
@@ -74,12 +167,74 @@ func (db *Database) GetEntry(entry string) (*storage.Data, error) {
 
 // AddEntry returns the specified Entry from the Database.
 func (db *Database) AddEntry(entry string, data *storage.Data) {
+	db.copyOnWrite()
+	if _, ok := db.entries[entry]; !ok {
+		db.insertKey(entry)
+	}
 	db.entries[entry] = data
+	for _, s := range db.secondary {
+		s.add(entry, data.Object)
+	}
+	db.added(entry, data)
 }
 
 // DeleteEntry deletes the specified Entry from the Database.
 func (db *Database) DeleteEntry(entry string) {
+	if _, ok := db.entries[entry]; ok {
+		db.copyOnWrite()
+		db.removeKey(entry)
+	}
 	delete(db.entries, entry)
+	for _, s := range db.secondary {
+		s.remove(entry)
+	}
+	db.removed(entry)
+}
+
+// SortedKeys returns the keys of all entries in the Database in sorted order. The returned slice must not be
+// modified. The Database must be read locked by the caller.
+func (db *Database) SortedKeys() []string {
+	db.keysLock.Lock()
+	defer db.keysLock.Unlock()
+	if db.keys == nil {
+		db.keys = make([]string, 0, len(db.entries))
+		for entry := range db.entries {
+			db.keys = append(db.keys, entry)
+		}
+		sort.Strings(db.keys)
+	}
+	return db.keys
+}
+
+// insertKey adds the key of a new entry to the sorted keys, if they are cached.
+func (db *Database) insertKey(entry string) {
+	db.keysLock.Lock()
+	defer db.keysLock.Unlock()
+	if db.keys == nil {
+		return
+	}
+	if db.keysShared {
+		// Clipping the slice makes Insert copy it
+		db.keys = slices.Clip(db.keys)
+		db.keysShared = false
+	}
+	i, _ := slices.BinarySearch(db.keys, entry)
+	db.keys = slices.Insert(db.keys, i, entry)
+}
+
+// removeKey removes the key of a deleted entry from the sorted keys, if they are cached.
+func (db *Database) removeKey(entry string) {
+	db.keysLock.Lock()
+	defer db.keysLock.Unlock()
+	i, ok := slices.BinarySearch(db.keys, entry)
+	if !ok {
+		return
+	}
+	if db.keysShared {
+		db.keys = slices.Clone(db.keys)
+		db.keysShared = false
+	}
+	db.keys = slices.Delete(db.keys, i, i+1)
 }
 
 // EntryMap returns the specified underlying EntryMap for the Database.
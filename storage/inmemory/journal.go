@@ -0,0 +1,193 @@
+package inmemory
+
+import (
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+)
+
+// SetJournal sets the function every change to the data set is recorded with, as an Event. It must be set before the
+// module is started. The changes to each Database are recorded in the order they are applied, while the Database is
+// still locked, so the journal must not block or send requests to the module.
+//
+// Every change sent to watches is recorded, including evictions whether or not they are sent to watches, except that
+// the removal of an expired Database is recorded as a single EventDeleteDB rather than an EventExpire for each of its
+// entries. The creation of an Index is recorded as an EventSetIndex.
+func (module *InMemoryModule) SetJournal(journal func(events ...*storage.Event)) {
+	module.journal = journal
+}
+
+// record records the events in the journal, if one is set. The caller must hold the lock of every Index or Database
+// changed.
+func (imm *InMemoryModule) record(events ...*storage.Event) {
+	if imm.journal != nil && len(events) > 0 {
+		imm.journal(events...)
+	}
+}
+
+// State returns the events which recreate the data set: an EventSetIndex for every Index, followed by an EventSet for
+// every Entry which has not expired. held is called while every Index and Database is locked, so the events include
+// every change recorded in the journal before held is called, and none after.
+func (module *InMemoryModule) State(held func()) []*storage.Event {
+	now := time.Now().UnixNano()
+	snap, _ := module.takeSnapshot("", now, held)
+	defer snap.release()
+
+	var events []*storage.Event
+	for name := range snap.indexes {
+		events = append(events, &storage.Event{
+			Type:  storage.EventSetIndex,
+			Index: name,
+		})
+	}
+	for name, dbs := range snap.indexes {
+		for dbName, view := range dbs {
+			for entry, data := range view.entries {
+				if data.Expired(now) {
+					continue
+				}
+				events = append(events, &storage.Event{
+					Type:  storage.EventSet,
+					Index: name,
+					DB:    dbName,
+					Entry: entry,
+					New:   data,
+				})
+			}
+		}
+	}
+	return events
+}
+
+// Apply applies events recorded in the journal of another module, such as by State, to the data set. Entries are
+// stored with the Data of the event, keeping its version, modification and expiry times. The changes are recorded in
+// the journal of this module and sent to matching watches. Databases created by Apply do not expire, as the removal of
+// expired Databases is applied from EventDeleteDB events.
+func (module *InMemoryModule) Apply(events ...*storage.Event) {
+	now := time.Now().UnixNano()
+	changes := make([]*storage.Event, 0, len(events))
+	for _, event := range events {
+		if change := module.apply(event, now); change != nil {
+			changes = append(changes, change)
+		}
+	}
+	module.notify(changes...)
+}
+
+// raiseVersion raises the last version assigned to an Entry to at least version, so entries set afterwards get a
+// higher version than the entries stored with the Data of another module.
+func (imm *InMemoryModule) raiseVersion(version uint64) {
+	for {
+		last := imm.versions.Load()
+		if version <= last || imm.versions.CompareAndSwap(last, version) {
+			return
+		}
+	}
+}
+
+// apply applies a single event and returns the change to send to watches, if any.
+func (imm *InMemoryModule) apply(event *storage.Event, now int64) *storage.Event {
+	switch event.Type {
+	case storage.EventSetIndex:
+		imm.applyIndex(event.Index)
+		return nil
+	case storage.EventDeleteIndex:
+		imm.indexLock.Lock()
+		defer imm.indexLock.Unlock()
+		index, ok := imm.indexes[event.Index]
+		if !ok {
+			return nil
+		}
+		delete(imm.indexes, event.Index)
+		index.untrack()
+		change := &storage.Event{
+			Type:  storage.EventDeleteIndex,
+			Index: event.Index,
+		}
+		imm.record(change)
+		return change
+	}
+
+	index := imm.applyIndex(event.Index)
+	index.Lock()
+	defer index.Unlock()
+	db, err := index.GetDB(event.DB)
+	switch event.Type {
+	case storage.EventSet:
+		if err != nil {
+			db = NewDatabase()
+			db.track(imm, event.Index, event.DB)
+			index.AddDB(event.DB, db)
+		}
+		db.Lock()
+		defer db.Unlock()
+		old, err := db.GetEntry(event.Entry)
+		if err != nil || old.Expired(now) {
+			old = nil
+		}
+		imm.raiseVersion(event.New.Version)
+		db.AddEntry(event.Entry, event.New)
+		change := &storage.Event{
+			Type:  storage.EventSet,
+			Index: event.Index,
+			DB:    event.DB,
+			Entry: event.Entry,
+			Old:   old,
+			New:   event.New,
+		}
+		imm.record(change)
+		return change
+	case storage.EventDelete, storage.EventExpire, storage.EventEvict:
+		if err != nil {
+			return nil
+		}
+		db.Lock()
+		defer db.Unlock()
+		old, err := db.GetEntry(event.Entry)
+		if err != nil {
+			return nil
+		}
+		db.DeleteEntry(event.Entry)
+		change := &storage.Event{
+			Type:  event.Type,
+			Index: event.Index,
+			DB:    event.DB,
+			Entry: event.Entry,
+			Old:   old,
+		}
+		imm.record(change)
+		if old.Expired(now) {
+			return nil
+		}
+		return change
+	case storage.EventDeleteDB:
+		if err != nil {
+			return nil
+		}
+		index.DeleteDB(event.DB)
+		change := &storage.Event{
+			Type:  storage.EventDeleteDB,
+			Index: event.Index,
+			DB:    event.DB,
+		}
+		imm.record(change)
+		return change
+	}
+	return nil
+}
+
+// applyIndex returns the Index, creating it if needed.
+func (imm *InMemoryModule) applyIndex(name string) *Index {
+	imm.indexLock.Lock()
+	defer imm.indexLock.Unlock()
+	index, ok := imm.indexes[name]
+	if !ok {
+		index = NewIndex()
+		imm.indexes[name] = index
+		imm.record(&storage.Event{
+			Type:  storage.EventSetIndex,
+			Index: name,
+		})
+	}
+	return index
+}
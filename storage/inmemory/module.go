@@ -3,6 +3,8 @@ package inmemory
 import (
 	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/OneOfOne/xxhash"
 	"github.com/jbvmio/modules/coop"
@@ -29,20 +31,36 @@ type InMemoryModule struct {
 	// fields that are appropriate to identify this coordinator
 	Log *zap.Logger
 
-	name        string
-	class       string
-	intervals   int
-	numWorkers  int
-	expireGroup int64
-	minDistance int64
-	queueDepth  int
-	autoIndex   bool
+	name         string
+	class        string
+	intervals    int
+	numWorkers   int
+	expireGroup  int64
+	reapInterval int
+	minDistance  int64
+	queueDepth   int
+	autoIndex    bool
+	snapshotTTL  int64
+	limits       storage.Limits
+	evictEvents  bool
 
 	requestChannel chan *storage.Request
 	workersRunning sync.WaitGroup
 	mainRunning    sync.WaitGroup
+	reaperRunning  sync.WaitGroup
+	stopChannel    chan struct{}
 	indexes        map[string]*Index
+	indexLock      sync.RWMutex
+	watchers       *watchers
+	snapshots      *snapshots
 	workers        []chan *storage.Request
+	handlers       map[storage.RequestConstant]func(*storage.Request, *zap.Logger)
+	journal        func(events ...*storage.Event)
+	limiter        *Limiter
+
+	// versions is the last version assigned to an Entry. It is shared by all Databases, so the version of an Entry
+	// keeps increasing when its Database is deleted and created again.
+	versions atomic.Uint64
 
 	quitChannel chan struct{}
 	running     *sync.WaitGroup
@@ -78,8 +96,26 @@ func (module *InMemoryModule) Init(quitChannel chan struct{}, running *sync.Wait
 }
 
 // Configure validates the configuration for the module, creates a channel to receive requests on, and sets up the
-// storage map. If no expiration time for groups is set, a default value of 7 days is used. If no interval count is
-// set, a default of 10 intervals is used. If no worker count is set, a default of 10 workers is used.
+// storage map. If no interval count is set, a default of 10 intervals is used. If no worker count is set, a default
+// of 10 workers is used. Expired databases and entries are reaped every reap-interval seconds, 60 by default.
+//
+// The interval count (intervals) is the number of values kept in each Entry written by TypeAppendEntry requests.
+// Values appended less than min-distance seconds after the newest value are dropped. The min-distance defaults to 0,
+// which only drops values older than the newest value.
+//
+// The expiration time for groups (expire-group) is the default TTL of every Database, in seconds. A Database that is
+// not accessed within its TTL is removed. It defaults to 0, which disables Database expiration, so Databases are
+// only removed when expire-group or a DBTTL is set.
+//
+// The memory used can be bounded by max-entries, the most entries across all Databases, max-db-entries, the most
+// entries in each Database, and max-bytes, the approximate most bytes across all Databases as estimated by
+// storage.EntrySize. All are unlimited by default. Once a limit is reached, setting an Entry evicts other entries
+// by the eviction-policy, either lru (the default), lfu or fifo. Evicted entries are counted, and sent to watches as
+// storage.EventEvict events if evict-events is set. Entries set by a TypeTransaction request are evicted once it is
+// committed. The limits can be shared with other modules using SetLimiter.
+//
+// Snapshots created without a TTL are released after snapshot-ttl seconds, 3600 by default. A snapshot-ttl of 0 keeps
+// them until they are released.
 func (module *InMemoryModule) Configure() { //name string, configRoot string) {
 	module.Log.Info("configuring inmemory module")
 	configRoot := `modules.inmemory`
@@ -92,26 +128,63 @@ func (module *InMemoryModule) Configure() { //name string, configRoot string) {
 
 	// Set defaults for configs if needed
 	viper.SetDefault(configRoot+".intervals", 10)
-	viper.SetDefault(configRoot+".expire-group", 604800)
+	viper.SetDefault(configRoot+".expire-group", 0)
+	viper.SetDefault(configRoot+".reap-interval", 60)
 	viper.SetDefault(configRoot+".workers", 10)
 	viper.SetDefault(configRoot+".queue-depth", 1)
 	viper.SetDefault(configRoot+".auto-index", true)
+	viper.SetDefault(configRoot+".snapshot-ttl", 3600)
 	module.intervals = viper.GetInt(configRoot + ".intervals")
 	module.expireGroup = viper.GetInt64(configRoot + ".expire-group")
+	module.reapInterval = viper.GetInt(configRoot + ".reap-interval")
 	module.numWorkers = viper.GetInt(configRoot + ".workers")
 	module.minDistance = viper.GetInt64(configRoot + ".min-distance")
 	module.queueDepth = viper.GetInt(configRoot + ".queue-depth")
 	module.autoIndex = viper.GetBool(configRoot + ".auto-index")
+	module.snapshotTTL = viper.GetInt64(configRoot + ".snapshot-ttl")
+	module.limits.MaxEntries = viper.GetInt(configRoot + ".max-entries")
+	module.limits.MaxDBEntries = viper.GetInt(configRoot + ".max-db-entries")
+	module.limits.MaxBytes = viper.GetInt64(configRoot + ".max-bytes")
+	module.evictEvents = viper.GetBool(configRoot + ".evict-events")
+	policy, err := storage.ParseEvictionPolicy(viper.GetString(configRoot + ".eviction-policy"))
+	if err != nil {
+		panic("inmemory module " + err.Error())
+	}
+	module.limits.Policy = policy
+
+	if module.reapInterval < 1 {
+		panic("inmemory module reap-interval must be at least 1 second")
+	}
+	if module.intervals < 1 {
+		panic("inmemory module intervals must be at least 1")
+	}
+	if module.snapshotTTL < 0 {
+		panic("inmemory module snapshot-ttl must not be negative")
+	}
+	if module.minDistance < 0 {
+		panic("inmemory module min-distance must not be negative")
+	}
+	if module.limits.MaxEntries < 0 || module.limits.MaxDBEntries < 0 || module.limits.MaxBytes < 0 {
+		panic("inmemory module max-entries, max-db-entries and max-bytes must not be negative")
+	}
 
 	module.requestChannel = make(chan *storage.Request, module.queueDepth)
 	module.workersRunning = sync.WaitGroup{}
 	module.mainRunning = sync.WaitGroup{}
+	module.reaperRunning = sync.WaitGroup{}
+	module.stopChannel = make(chan struct{})
 	module.indexes = make(map[string]*Index)
+	module.watchers = newWatchers()
+	module.snapshots = newSnapshots()
+	module.limiter = nil
+	if module.limits.Enabled() {
+		module.limiter = NewLimiter(module.limits)
+	}
 }
 
 // Start sets up the rest of the storage map for each configured cluster. It then starts the configured number of
 // worker routines to handle requests. Finally, it starts a main loop which will receive requests and hash them to the
-// correct worker.
+// correct worker, and a reaper which removes expired data.
 func (module *InMemoryModule) Start() error {
 	module.Log.Info("starting")
 
@@ -121,6 +194,7 @@ func (module *InMemoryModule) Start() error {
 	}
 
 	// Start the appropriate number of workers, with a channel for each
+	module.handlers = module.requestTypes()
 	module.workers = make([]chan *storage.Request, module.numWorkers)
 	for i := 0; i < module.numWorkers; i++ {
 		module.workers[i] = make(chan *storage.Request, module.queueDepth)
@@ -130,14 +204,22 @@ func (module *InMemoryModule) Start() error {
 
 	module.mainRunning.Add(1)
 	go module.mainLoop()
+
+	module.reaperRunning.Add(1)
+	go module.reaper()
 	return nil
 }
 
 // Stop closes the incoming request channel, which will close the main loop. It then closes each of the worker
-// channels, to close the workers, and waits for all goroutines to exit before returning.
+// channels, to close the workers, and waits for all goroutines to exit before returning. The entries of the module
+// are then released from its Limiter, which may be shared with other modules.
 func (module *InMemoryModule) Stop() error {
 	module.Log.Info("stopping")
 
+	close(module.stopChannel)
+	module.reaperRunning.Wait()
+	module.watchers.running.Wait()
+
 	close(module.requestChannel)
 	module.mainRunning.Wait()
 
@@ -146,6 +228,10 @@ func (module *InMemoryModule) Stop() error {
 	}
 	module.workersRunning.Wait()
 
+	// Release the entries from the Limiter, which may be shared with other modules
+	for _, index := range module.indexes {
+		index.untrack()
+	}
 	return nil
 }
 
@@ -154,12 +240,50 @@ func (module *InMemoryModule) mainLoop() {
 
 	for r := range module.requestChannel {
 		switch r.RequestType {
-		case storage.TypeFetchIndexes, storage.TypeFetchEntries, storage.TypeSetIndex:
+		case storage.TypeFetchIndexes, storage.TypeFetchEntries, storage.TypeSetIndex, storage.TypeWatch,
+			storage.TypeFetchDatabases, storage.TypeSnapshot, storage.TypeReleaseSnapshot:
 			// Send to any worker
 			module.workers[int(rand.Int31n(int32(module.numWorkers)))] <- r
-		case storage.TypeDeleteEntry, storage.TypeSetEntry, storage.TypeFetchEntry:
+		case storage.TypeDeleteIndex:
+			// Hold every worker, as any of them may have requests for the Index queued
+			workers := make([]int, module.numWorkers)
+			for i := range workers {
+				workers[i] = i
+			}
+			module.hold(r, workers)
+		case storage.TypeTransaction:
+			// Hold the workers of every DB of the transaction, so it keeps its order with other requests for them
+			targets := make(map[int]bool)
+			workers := make([]int, 0, len(r.Operations))
+			for _, op := range r.Operations {
+				if worker := module.worker(op.Index, op.DB); !targets[worker] {
+					targets[worker] = true
+					workers = append(workers, worker)
+				}
+			}
+			module.hold(r, workers)
+		case storage.TypeDeleteEntry, storage.TypeSetEntry, storage.TypeFetchEntry, storage.TypeCompareAndSet,
+			storage.TypeDeleteDB, storage.TypeScan, storage.TypeSetSecondaryIndex, storage.TypeFetchByIndex,
+			storage.TypeIncrement, storage.TypeDecrement, storage.TypeAppendEntry:
 			// Hash to a consistent worker
-			module.workers[int(xxhash.ChecksumString64(r.Index+r.DB)%uint64(module.numWorkers))] <- r
+			module.workers[module.worker(r.Index, r.DB)] <- r
+		case storage.TypeBatch:
+			// Split the operations by the worker of their DB, so each keeps its order with other requests for the DB
+			split := storage.SplitBatch(r, func(op *storage.Request) int {
+				return module.worker(op.Index, op.DB)
+			})
+			switch len(split.Targets) {
+			case 0:
+				module.workers[int(rand.Int31n(int32(module.numWorkers)))] <- r
+				continue
+			case 1:
+				module.workers[split.Targets[0]] <- r
+				continue
+			}
+			for n, worker := range split.Targets {
+				module.workers[worker] <- split.Requests[n]
+			}
+			go split.Merge()
 		default:
 			module.Log.Error("unknown storage request type",
 				zap.Int("request_type", int(r.RequestType)),
@@ -171,6 +295,53 @@ func (module *InMemoryModule) mainLoop() {
 	}
 }
 
+// typeHold is the request type sent to workers by hold. It is never sent to the module.
+const typeHold storage.RequestConstant = -1
+
+// hold queues a hold on each of the workers, then handles the request once every worker has handled the requests
+// queued before the hold, and releases the workers. The request is handled without blocking the main loop, and
+// requests queued on the workers after the hold are only handled once it is released.
+func (module *InMemoryModule) hold(r *storage.Request, workers []int) {
+	release := make(chan struct{})
+	holds := make([]*storage.Request, len(workers))
+	for n, worker := range workers {
+		holds[n] = &storage.Request{
+			RequestType: typeHold,
+			Reply:       make(chan interface{}),
+			Done:        release,
+		}
+		module.workers[worker] <- holds[n]
+	}
+	logger := module.Log.With(zap.Ints("workers", workers))
+	go func() {
+		defer close(release)
+		for _, held := range holds {
+			<-held.Reply
+		}
+		module.handle(r, logger)
+	}()
+}
+
+func (module *InMemoryModule) reaper() {
+	defer module.reaperRunning.Done()
+
+	ticker := time.NewTicker(time.Duration(module.reapInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			module.reap()
+		case <-module.stopChannel:
+			return
+		}
+	}
+}
+
+// worker returns the worker which handles the requests for the DB of the Index.
+func (module *InMemoryModule) worker(index, db string) int {
+	return int(xxhash.ChecksumString64(index+db) % uint64(module.numWorkers))
+}
+
 // GetCommunicationChannel returns the RequestChannel that has been setup for this module.
 func (module *InMemoryModule) GetCommunicationChannel() chan *storage.Request {
 	return module.requestChannel
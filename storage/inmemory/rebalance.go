@@ -0,0 +1,140 @@
+package inmemory
+
+import "github.com/jbvmio/modules/storage"
+
+// Flush waits until every request sent to the module before Flush was called has been handled. TypeWatch requests
+// are handled once the watch is added. Requests sent while Flush is running are not waited for.
+func (module *InMemoryModule) Flush() {
+	// The main loop dispatches requests in order, so once a barrier sent through it is handled, every earlier
+	// request has been queued on a worker. A barrier on every worker then waits for those to be handled.
+	barrier(module.requestChannel)
+	for _, worker := range module.workers {
+		barrier(worker)
+	}
+}
+
+func barrier(requestChannel chan *storage.Request) {
+	request := &storage.Request{
+		RequestType: storage.TypeFetchIndexes,
+		Reply:       make(chan interface{}, 1),
+	}
+	requestChannel <- request
+	for range request.Reply {
+	}
+}
+
+// Databases returns the names of all Databases by Index, including Indexes without any Databases.
+func (module *InMemoryModule) Databases() map[string][]string {
+	module.indexLock.RLock()
+	indexes := make(map[string]*Index, len(module.indexes))
+	for name, index := range module.indexes {
+		indexes[name] = index
+	}
+	module.indexLock.RUnlock()
+
+	dbs := make(map[string][]string, len(indexes))
+	for name, index := range indexes {
+		index.RLock()
+		dbList := make([]string, 0, len(*index.DBMap()))
+		for db := range *index.DBMap() {
+			dbList = append(dbList, db)
+		}
+		index.RUnlock()
+		dbs[name] = dbList
+	}
+	return dbs
+}
+
+// Declarations returns the secondary indexes declared for the Databases of every Index, by Index, Database and index
+// name, including those declared for Databases which do not exist.
+func (module *InMemoryModule) Declarations() map[string]map[string]map[string]storage.IndexFunc {
+	module.indexLock.RLock()
+	indexes := make(map[string]*Index, len(module.indexes))
+	for name, index := range module.indexes {
+		indexes[name] = index
+	}
+	module.indexLock.RUnlock()
+
+	declarations := make(map[string]map[string]map[string]storage.IndexFunc)
+	for name, index := range indexes {
+		index.RLock()
+		for db, secondary := range index.secondary {
+			if len(secondary) == 0 {
+				continue
+			}
+			if declarations[name] == nil {
+				declarations[name] = make(map[string]map[string]storage.IndexFunc)
+			}
+			declarations[name][db] = make(map[string]storage.IndexFunc, len(secondary))
+			for s, extract := range secondary {
+				declarations[name][db][s] = extract
+			}
+		}
+		index.RUnlock()
+	}
+	return declarations
+}
+
+// DeclareSecondaryIndex declares a secondary index for the named Database of the Index, creating the Index if
+// needed, as returned by the Declarations of another module. The index is applied to the Database if it exists and
+// does not have an index of the same name, such as a Database attached with AttachDB. A nil extract removes the
+// declaration, but not the index of an existing Database.
+func (module *InMemoryModule) DeclareSecondaryIndex(index, db, name string, extract storage.IndexFunc) {
+	i := module.applyIndex(index)
+	i.Lock()
+	defer i.Unlock()
+	i.declare(db, name, extract)
+	if database, ok := i.db[db]; ok {
+		database.Lock()
+		if _, ok := database.secondary[name]; !ok {
+			database.SetSecondaryIndex(name, extract)
+		}
+		database.Unlock()
+	}
+}
+
+// DetachDB removes the Database from the Index and returns it, so it can be attached to another module. No event is
+// sent to watchers. Requests for the Database which are still queued are not moved with it, so the module should be
+// flushed first.
+func (module *InMemoryModule) DetachDB(index, db string) (*Database, error) {
+	i, err := module.getIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	i.Lock()
+	defer i.Unlock()
+	database, err := i.GetDB(db)
+	if err != nil {
+		return nil, err
+	}
+	i.DeleteDB(db)
+	return database, nil
+}
+
+// AttachDB adds a Database returned by DetachDB to the Index, creating the Index if needed. Any Database of the same
+// name is replaced, and the secondary indexes of the Database are declared for it in the Index. No event is sent to
+// watchers, and no entries are evicted until the next entry is set.
+func (module *InMemoryModule) AttachDB(index, db string, database *Database) {
+	database.track(module, index, db)
+	database.Lock()
+	for _, data := range database.entries {
+		module.raiseVersion(data.Version)
+	}
+	database.Unlock()
+	module.indexLock.Lock()
+	i, ok := module.indexes[index]
+	if !ok {
+		i = NewIndex()
+		module.indexes[index] = i
+	}
+	module.indexLock.Unlock()
+
+	i.Lock()
+	database.Lock()
+	for name, s := range database.secondary {
+		i.declare(db, name, s.extract)
+	}
+	database.Unlock()
+	i.AddDB(db, database)
+	i.Unlock()
+}
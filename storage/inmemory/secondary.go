@@ -0,0 +1,193 @@
+package inmemory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// secondaryIndex maps the keys returned by an IndexFunc to the entries of a Database.
+type secondaryIndex struct {
+	extract storage.IndexFunc
+
+	// entries holds the entries under each key, and keys the keys of each entry so they can be removed.
+	entries map[string]map[string]struct{}
+	keys    map[string][]string
+}
+
+func newSecondaryIndex(extract storage.IndexFunc) *secondaryIndex {
+	return &secondaryIndex{
+		extract: extract,
+		entries: make(map[string]map[string]struct{}),
+		keys:    make(map[string][]string),
+	}
+}
+
+// add indexes the entry under the keys of the Object, replacing any keys previously indexed for the entry.
+func (s *secondaryIndex) add(entry string, obj storage.Object) {
+	s.remove(entry)
+	keys := s.keysOf(obj)
+	if len(keys) == 0 {
+		return
+	}
+	for _, key := range keys {
+		entries, ok := s.entries[key]
+		if !ok {
+			entries = make(map[string]struct{})
+			s.entries[key] = entries
+		}
+		entries[entry] = struct{}{}
+	}
+	s.keys[entry] = keys
+}
+
+// keysOf returns the keys of the Object. An IndexFunc which panics indexes the Object under no keys, rather than
+// stopping the worker setting the entry.
+func (s *secondaryIndex) keysOf(obj storage.Object) (keys []string) {
+	defer func() {
+		if recover() != nil {
+			keys = nil
+		}
+	}()
+	return s.extract(obj)
+}
+
+// remove removes the entry from all of its keys.
+func (s *secondaryIndex) remove(entry string) {
+	for _, key := range s.keys[entry] {
+		delete(s.entries[key], entry)
+		if len(s.entries[key]) == 0 {
+			delete(s.entries, key)
+		}
+	}
+	delete(s.keys, entry)
+}
+
+// SetSecondaryIndex declares a secondary index for the Database and indexes all existing entries. An existing index
+// of the same name is replaced. A nil extract removes the index. The Database must be locked by the caller.
+func (db *Database) SetSecondaryIndex(name string, extract storage.IndexFunc) {
+	if extract == nil {
+		delete(db.secondary, name)
+		return
+	}
+	if db.secondary == nil {
+		db.secondary = make(map[string]*secondaryIndex)
+	}
+	s := newSecondaryIndex(extract)
+	for entry, data := range db.entries {
+		s.add(entry, data.Object)
+	}
+	db.secondary[name] = s
+}
+
+// SetSecondaryIndex declares a secondary index for the named Database, and applies it to the Database if it exists.
+// The declaration is kept by the Index, so it is applied again when the Database is deleted and created again. An
+// existing index of the same name is replaced, and a nil extract removes the index.
+func (i *Index) SetSecondaryIndex(db, name string, extract storage.IndexFunc) {
+	i.Lock()
+	defer i.Unlock()
+	i.declare(db, name, extract)
+	if database, ok := i.db[db]; ok {
+		database.Lock()
+		database.SetSecondaryIndex(name, extract)
+		database.Unlock()
+	}
+}
+
+// declare records the declaration of a secondary index for the named Database. The Index must be locked by the
+// caller.
+func (i *Index) declare(db, name string, extract storage.IndexFunc) {
+	if extract == nil {
+		delete(i.secondary[db], name)
+		return
+	}
+	if i.secondary == nil {
+		i.secondary = make(map[string]map[string]storage.IndexFunc)
+	}
+	if i.secondary[db] == nil {
+		i.secondary[db] = make(map[string]storage.IndexFunc)
+	}
+	i.secondary[db][name] = extract
+}
+
+// IndexedEntries returns the entries indexed under the key of the named secondary index, in sorted order.
+// The Database must be read locked by the caller.
+func (db *Database) IndexedEntries(name, key string) ([]string, error) {
+	s, ok := db.secondary[name]
+	if !ok {
+		return nil, Errf(ErrUnknownSecondaryIndex, "%v", name)
+	}
+	entryList := make([]string, 0, len(s.entries[key]))
+	for entry := range s.entries[key] {
+		entryList = append(entryList, entry)
+	}
+	sort.Strings(entryList)
+	return entryList, nil
+}
+
+func (imm *InMemoryModule) setSecondaryIndex(request *storage.Request, requestLogger *zap.Logger) {
+	if _, err := imm.getOrCreateDB(request, time.Now().UnixNano(), requestLogger); err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		return
+	}
+	index, err := imm.getIndex(request.Index)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(err),
+		)
+		return
+	}
+	requestLogger.Debug("Setting Secondary Index",
+		zap.String("secondary_index", request.Secondary.Name),
+	)
+
+	index.SetSecondaryIndex(request.DB, request.Secondary.Name, request.Secondary.Extract)
+	requestLogger.Debug("ok")
+}
+
+func (imm *InMemoryModule) fetchByIndex(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Fetching Entries By Index",
+		zap.String("secondary_index", request.Secondary.Name),
+		zap.String("key", request.Secondary.Key),
+	)
+
+	db, err := imm.getDB(request.Index, request.DB)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Database",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+
+	now := time.Now().UnixNano()
+	db.RLock()
+	entryList, err := db.IndexedEntries(request.Secondary.Name, request.Secondary.Key)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Secondary Index",
+			zap.Error(err),
+		)
+		db.RUnlock()
+		request.Respond(err)
+		return
+	}
+	entries := make([]*storage.ScanEntry, 0, len(entryList))
+	for _, entry := range entryList {
+		if data, err := db.GetEntry(entry); err == nil && !data.Expired(now) {
+			entries = append(entries, &storage.ScanEntry{
+				Entry: entry,
+				Data:  data,
+			})
+		}
+	}
+	db.RUnlock()
+
+	requestLogger.Debug("ok")
+	request.Respond(entries)
+}
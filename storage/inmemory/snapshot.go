@@ -0,0 +1,324 @@
+package inmemory
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// snapshot is a read-only view of one or all Indexes at a point in time. The entries of each Database are shared
+// with the live Database until it is next written, when the live Database copies them.
+type snapshot struct {
+	at      int64
+	expires int64
+	indexes map[string]map[string]*dbView
+}
+
+// dbView is the shared entries of a Database at the time of a snapshot. The entries are never modified.
+type dbView struct {
+	db      *Database
+	gen     uint64
+	entries map[string]*storage.Data
+
+	keysLock sync.Mutex
+	keys     []string
+}
+
+// snapshots holds the open snapshots by ID.
+type snapshots struct {
+	lock  sync.Mutex
+	byID  map[string]*snapshot
+	count uint64
+}
+
+func newSnapshots() *snapshots {
+	return &snapshots{
+		byID: make(map[string]*snapshot),
+	}
+}
+
+// add stores the snapshot and returns its ID.
+func (s *snapshots) add(snap *snapshot) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.count++
+	id := strconv.FormatInt(snap.at, 36) + "-" + strconv.FormatUint(s.count, 10)
+	s.byID[id] = snap
+	return id
+}
+
+// get returns the snapshot, or an error if it was released or has expired at the given time.
+func (s *snapshots) get(id string, now int64) (*snapshot, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snap, ok := s.byID[id]
+	if !ok || (snap.expires > 0 && now >= snap.expires) {
+		return nil, Errf(ErrUnknownSnapshot, "%v", id)
+	}
+	return snap, nil
+}
+
+// remove removes the snapshot and returns it, or nil if it does not exist.
+func (s *snapshots) remove(id string) *snapshot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snap := s.byID[id]
+	delete(s.byID, id)
+	return snap
+}
+
+// expired removes and returns the snapshots which have expired at the given time.
+func (s *snapshots) expired(now int64) []*snapshot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var expired []*snapshot
+	for id, snap := range s.byID {
+		if snap.expires > 0 && now >= snap.expires {
+			expired = append(expired, snap)
+			delete(s.byID, id)
+		}
+	}
+	return expired
+}
+
+// release stops sharing the entries of every Database in the snapshot, so writes no longer copy them.
+func (snap *snapshot) release() {
+	for _, dbs := range snap.indexes {
+		for _, view := range dbs {
+			view.db.unshare(view.gen)
+		}
+	}
+}
+
+// share returns a view of the entries of the Database, which are copied before the Database is next written.
+// The Database must be locked by the caller.
+func (db *Database) share() *dbView {
+	db.shares++
+	db.keysLock.Lock()
+	keys := db.keys
+	db.keysShared = keys != nil
+	db.keysLock.Unlock()
+	return &dbView{
+		db:      db,
+		gen:     db.gen,
+		entries: db.entries,
+		keys:    keys,
+	}
+}
+
+// unshare releases a view returned by share. If the entries have been copied since, there is nothing to release.
+func (db *Database) unshare(gen uint64) {
+	db.Lock()
+	defer db.Unlock()
+	if db.gen == gen && db.shares > 0 {
+		db.shares--
+	}
+}
+
+// copyOnWrite copies the entries of the Database if they are shared with a snapshot. It is called before every
+// write. The Database must be locked by the caller.
+func (db *Database) copyOnWrite() {
+	if db.shares == 0 {
+		return
+	}
+	entries := make(map[string]*storage.Data, len(db.entries))
+	for entry, data := range db.entries {
+		entries[entry] = data
+	}
+	db.entries = entries
+	db.gen++
+	db.shares = 0
+}
+
+// sortedKeys returns the keys of all entries in the view in sorted order. The returned slice must not be modified.
+func (view *dbView) sortedKeys() []string {
+	view.keysLock.Lock()
+	defer view.keysLock.Unlock()
+	if view.keys == nil {
+		view.keys = make([]string, 0, len(view.entries))
+		for entry := range view.entries {
+			view.keys = append(view.keys, entry)
+		}
+		sort.Strings(view.keys)
+	}
+	return view.keys
+}
+
+// createSnapshot services TypeSnapshot requests. Every Index in the snapshot and every Database within it is
+// locked while the view is taken, in sorted order, so the view is consistent across Databases.
+func (imm *InMemoryModule) createSnapshot(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Creating Snapshot")
+
+	now := time.Now().UnixNano()
+	snap, err := imm.takeSnapshot(request.Index, now, nil)
+	if err != nil {
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	switch {
+	case request.TTL > 0:
+		snap.expires = now + int64(request.TTL)
+	case imm.snapshotTTL > 0:
+		snap.expires = now + int64(time.Duration(imm.snapshotTTL)*time.Second)
+	}
+	id := imm.snapshots.add(snap)
+
+	requestLogger.Debug("ok",
+		zap.String("snapshot", id),
+	)
+	request.Respond(id)
+}
+
+// takeSnapshot returns a snapshot of the Index, or of every Index if index is empty. Expired Databases are left out.
+// If held is not nil, it is called while every Index and Database in the snapshot is still locked.
+func (imm *InMemoryModule) takeSnapshot(index string, now int64, held func()) (*snapshot, error) {
+	imm.indexLock.RLock()
+	defer imm.indexLock.RUnlock()
+
+	names := make([]string, 0, len(imm.indexes))
+	if index != "" {
+		if _, ok := imm.indexes[index]; !ok {
+			return nil, Errf(ErrUnknownIndex, "%v", index)
+		}
+		names = append(names, index)
+	} else {
+		for name := range imm.indexes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	snap := &snapshot{
+		at:      now,
+		indexes: make(map[string]map[string]*dbView, len(names)),
+	}
+	for _, name := range names {
+		i := imm.indexes[name]
+		i.Lock()
+		defer i.Unlock()
+
+		dbNames := make([]string, 0, len(*i.DBMap()))
+		for dbName, db := range *i.DBMap() {
+			if !db.Expired(now) {
+				dbNames = append(dbNames, dbName)
+			}
+		}
+		sort.Strings(dbNames)
+		views := make(map[string]*dbView, len(dbNames))
+		for _, dbName := range dbNames {
+			db := (*i.DBMap())[dbName]
+			db.Lock()
+			defer db.Unlock()
+			views[dbName] = db.share()
+		}
+		snap.indexes[name] = views
+	}
+	if held != nil {
+		held()
+	}
+	return snap, nil
+}
+
+// releaseSnapshot services TypeReleaseSnapshot requests.
+func (imm *InMemoryModule) releaseSnapshot(request *storage.Request, requestLogger *zap.Logger) {
+	snap := imm.snapshots.remove(request.Snapshot)
+	if snap == nil {
+		requestLogger.Error("Error Retrieving Snapshot",
+			zap.Error(Errf(ErrUnknownSnapshot, "%v", request.Snapshot)),
+		)
+		return
+	}
+	requestLogger.Debug("Releasing Snapshot",
+		zap.String("snapshot", request.Snapshot),
+	)
+	snap.release()
+	requestLogger.Debug("ok")
+}
+
+// fetchSnapshot services fetch requests which read from a snapshot. Entries are filtered by expiry at the time the
+// snapshot was taken.
+func (imm *InMemoryModule) fetchSnapshot(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Fetching From Snapshot",
+		zap.String("snapshot", request.Snapshot),
+	)
+
+	snap, err := imm.snapshots.get(request.Snapshot, time.Now().UnixNano())
+	if err != nil {
+		requestLogger.Error("Error Retrieving Snapshot",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+	reply, err := snap.fetch(request)
+	if err != nil {
+		requestLogger.Error("Error Fetching From Snapshot",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+
+	requestLogger.Debug("ok")
+	request.Respond(reply)
+}
+
+// fetch returns the reply to a fetch request from the snapshot.
+func (snap *snapshot) fetch(request *storage.Request) (interface{}, error) {
+	if request.RequestType == storage.TypeFetchIndexes {
+		indexList := make([]string, 0, len(snap.indexes))
+		for name := range snap.indexes {
+			indexList = append(indexList, name)
+		}
+		return indexList, nil
+	}
+
+	dbs, ok := snap.indexes[request.Index]
+	if !ok {
+		return nil, Errf(ErrUnknownIndex, "%v", request.Index)
+	}
+	if request.RequestType == storage.TypeFetchDatabases {
+		dbList := make([]string, 0, len(dbs))
+		for name := range dbs {
+			dbList = append(dbList, name)
+		}
+		return dbList, nil
+	}
+
+	view, ok := dbs[request.DB]
+	if !ok {
+		return nil, Errf(ErrUnknownDB, "%v", request.DB)
+	}
+	switch request.RequestType {
+	case storage.TypeFetchEntries:
+		entryList := make([]string, 0, len(view.entries))
+		for entry, data := range view.entries {
+			if !data.Expired(snap.at) {
+				entryList = append(entryList, entry)
+			}
+		}
+		return entryList, nil
+	case storage.TypeFetchEntry:
+		data, ok := view.entries[request.Entry]
+		if !ok || data.Expired(snap.at) {
+			return nil, Errf(ErrUnknownEntry, "%v", request.Entry)
+		}
+		return data, nil
+	case storage.TypeScan:
+		return request.Scan.Page(view.sortedKeys(), func(entry string) (*storage.Data, bool) {
+			data, ok := view.entries[entry]
+			return data, ok && !data.Expired(snap.at)
+		})
+	}
+	return nil, storage.Errorf(storage.CodeInvalidRequest, "%v cannot read from a snapshot", request.RequestType)
+}
@@ -0,0 +1,239 @@
+package inmemory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// txnUndo holds the previous Data of an Entry changed by a transaction, so it can be restored if the transaction is
+// aborted. A nil old means the Entry did not exist.
+type txnUndo struct {
+	db    *Database
+	entry string
+	old   *storage.Data
+}
+
+// transaction applies the operations of a TypeTransaction request. Operations are applied in order and undone if any
+// of them fails. Watchers are only notified once the transaction is committed, after which entries are evicted if the
+// memory limits of the module are reached.
+func (imm *InMemoryModule) transaction(request *storage.Request, requestLogger *zap.Logger) {
+	defer close(request.Reply)
+	requestLogger.Debug("Applying Transaction",
+		zap.Int("operations", len(request.Operations)),
+	)
+
+	index, err := imm.getIndex(request.Index)
+	if err != nil && imm.autoIndex {
+		requestLogger.Debug("Auto-Adding Index")
+		imm.addIndex(request, requestLogger)
+		index, err = imm.getIndex(request.Index)
+	}
+	if err != nil {
+		requestLogger.Error("Error Retrieving Index",
+			zap.Error(err),
+		)
+		request.Respond(err)
+		return
+	}
+
+	result, events, evicted := imm.applyTransaction(index, request, time.Now().UnixNano())
+	if !result.Committed {
+		requestLogger.Debug("Transaction Aborted",
+			zap.Error(result.Failed().Err),
+		)
+		request.Respond(result)
+		return
+	}
+	imm.notify(events...)
+	imm.notifyEvicted(evicted)
+	requestLogger.Debug("ok")
+	request.Respond(result)
+}
+
+// applyTransaction applies the operations of a TypeTransaction request to the Index. Only the Databases used by the
+// transaction are locked, in sorted order, so concurrent transactions cannot deadlock. Requests for them are not
+// handled meanwhile, as their workers are held by the main loop. Once committed, entries other than the last Entry
+// set in each Database are evicted if the memory limits are reached, and the evictions returned.
+func (imm *InMemoryModule) applyTransaction(index *Index, request *storage.Request, now int64) (*storage.TransactionResult, []*storage.Event, []*storage.Event) {
+	writes := make(map[string]bool)
+	for _, op := range request.Operations {
+		writes[op.DB] = writes[op.DB] || op.RequestType == storage.TypeSetEntry
+	}
+	names := make([]string, 0, len(writes))
+	for name := range writes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Databases which do not exist, or have expired, are only created if the transaction writes to them, and are
+	// removed again if it is aborted. Databases written to are touched, so they cannot expire before it is committed.
+	dbs := make(map[string]*Database, len(names))
+	created := make(map[string]*Database)
+	index.Lock()
+	for _, name := range names {
+		db, err := index.GetDB(name)
+		if err == nil && !db.Expired(now) {
+			if writes[name] {
+				db.Touch(now)
+			}
+			dbs[name] = db
+			continue
+		}
+		if writes[name] {
+			if err == nil {
+				// The expired Database is replaced
+				imm.record(&storage.Event{
+					Type:  storage.EventDeleteDB,
+					Index: request.Index,
+					DB:    name,
+				})
+			}
+			db = NewDatabase()
+			db.SetTTL(time.Duration(imm.expireGroup) * time.Second)
+			db.track(imm, request.Index, name)
+			db.Touch(now)
+			index.AddDB(name, db)
+			dbs[name] = db
+			created[name] = db
+		}
+	}
+	index.Unlock()
+	for _, name := range names {
+		if db := dbs[name]; db != nil {
+			db.Lock()
+		}
+	}
+	unlock := func() {
+		for _, name := range names {
+			if db := dbs[name]; db != nil {
+				db.Unlock()
+			}
+		}
+	}
+
+	result := &storage.TransactionResult{}
+	var undo []txnUndo
+	var events []*storage.Event
+	for _, op := range request.Operations {
+		opResult := &storage.OperationResult{
+			RequestType: op.RequestType,
+			Index:       op.Index,
+			DB:          op.DB,
+			Entry:       op.Entry,
+		}
+		result.Results = append(result.Results, opResult)
+		event, err := imm.applyTxnOperation(dbs[op.DB], op, opResult, now, &undo)
+		if err != nil {
+			opResult.Err = err
+			for i := len(undo) - 1; i >= 0; i-- {
+				if undo[i].old == nil {
+					undo[i].db.DeleteEntry(undo[i].entry)
+				} else {
+					undo[i].db.AddEntry(undo[i].entry, undo[i].old)
+				}
+			}
+			unlock()
+			index.Lock()
+			for name, db := range created {
+				if current, err := index.GetDB(name); err == nil && current == db {
+					index.DeleteDB(name)
+				}
+			}
+			index.Unlock()
+			return result, nil, nil
+		}
+		if event != nil {
+			events = append(events, event)
+		}
+	}
+
+	for _, op := range request.Operations {
+		if op.DBTTL > 0 {
+			dbs[op.DB].SetTTL(op.DBTTL)
+		}
+	}
+	for _, db := range dbs {
+		if db != nil {
+			db.Touch(now)
+		}
+	}
+	imm.record(events...)
+
+	// The last Entry set in each Database is kept, as with TypeBatch
+	sets := make(map[string]string)
+	for _, op := range request.Operations {
+		if op.RequestType == storage.TypeSetEntry {
+			sets[op.DB] = op.Entry
+		}
+	}
+	var evicted []*storage.Event
+	for name, set := range sets {
+		evicted = append(evicted, imm.evictDB(dbs[name], set)...)
+	}
+	unlock()
+	for name, set := range sets {
+		evicted = append(evicted, imm.evict(dbs[name], set)...)
+	}
+	result.Committed = true
+	return result, events, evicted
+}
+
+// applyTxnOperation applies a single operation of a transaction to the locked Database, recording any change in undo.
+func (imm *InMemoryModule) applyTxnOperation(db *Database, op *storage.Request, result *storage.OperationResult, now int64, undo *[]txnUndo) (*storage.Event, error) {
+	if db == nil {
+		return nil, Errf(ErrUnknownDB, "%v", op.DB)
+	}
+	old, err := db.GetEntry(op.Entry)
+	current := old
+	if err != nil || current.Expired(now) {
+		current = nil
+	}
+	if op.CheckVersion {
+		var version uint64
+		if current != nil {
+			version = current.Version
+		}
+		if version != op.Version {
+			return nil, Errf(ErrVersionConflict, "%v: expected version %d, found %d", op.Entry, op.Version, version)
+		}
+	}
+
+	switch op.RequestType {
+	case storage.TypeSetEntry:
+		*undo = append(*undo, txnUndo{db: db, entry: op.Entry, old: old})
+		event := imm.setEntry(db, op, now)
+		result.Data = event.New
+		return event, nil
+	case storage.TypeDeleteEntry:
+		if current == nil {
+			return nil, Errf(ErrUnknownEntry, "%v", op.Entry)
+		}
+		*undo = append(*undo, txnUndo{db: db, entry: op.Entry, old: old})
+		db.DeleteEntry(op.Entry)
+		return &storage.Event{
+			Type:  storage.EventDelete,
+			Index: op.Index,
+			DB:    op.DB,
+			Entry: op.Entry,
+			Old:   current,
+		}, nil
+	case storage.TypeFetchEntry:
+		if current == nil {
+			return nil, Errf(ErrUnknownEntry, "%v", op.Entry)
+		}
+		result.Data = current
+	case storage.TypeFetchEntries:
+		entryList := make([]string, 0, len(*db.EntryMap()))
+		for entry, data := range *db.EntryMap() {
+			if !data.Expired(now) {
+				entryList = append(entryList, entry)
+			}
+		}
+		result.Entries = entryList
+	}
+	return nil, nil
+}
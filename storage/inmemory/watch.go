@@ -0,0 +1,100 @@
+package inmemory
+
+import (
+	"sync"
+
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// watchers holds all active TypeWatch requests.
+type watchers struct {
+	lock    sync.RWMutex
+	running sync.WaitGroup
+	watches map[*storage.Request]*watch
+}
+
+// watch is an active TypeWatch request. Overflow is closed once an event could not be sent because the Reply
+// channel was full.
+type watch struct {
+	request  *storage.Request
+	overflow chan struct{}
+	once     sync.Once
+}
+
+func newWatchers() *watchers {
+	return &watchers{
+		watches: make(map[*storage.Request]*watch),
+	}
+}
+
+func (imm *InMemoryModule) addWatch(request *storage.Request, requestLogger *zap.Logger) {
+	requestLogger.Debug("Adding Watch")
+	w := &watch{
+		request:  request,
+		overflow: make(chan struct{}),
+	}
+	imm.watchers.running.Add(1)
+	imm.watchers.lock.Lock()
+	imm.watchers.watches[request] = w
+	imm.watchers.lock.Unlock()
+
+	// Remove the watch and close the Reply channel once it is cancelled, its context is done, it overflows or the
+	// module is stopped. Events are only sent while holding the read lock, so nothing is sent on Reply after it is
+	// closed here.
+	go func() {
+		defer imm.watchers.running.Done()
+		select {
+		case <-request.Done:
+			requestLogger.Debug("Watch Cancelled")
+		case <-request.Context().Done():
+			requestLogger.Debug("Watch Cancelled")
+		case <-w.overflow:
+			requestLogger.Warn("Watch Overflowed",
+				zap.Int("buffer", cap(request.Reply)),
+			)
+		case <-imm.stopChannel:
+		}
+		imm.watchers.lock.Lock()
+		delete(imm.watchers.watches, request)
+		imm.watchers.lock.Unlock()
+		close(request.Reply)
+	}()
+	requestLogger.Debug("ok")
+}
+
+// notify sends the given events to every matching watch. Events are sent on the worker handling the change, so
+// sending never blocks: a watch whose Reply channel is full is closed instead, and receives no further events. A
+// watcher whose Reply channel is closed before it cancelled the watch has missed events.
+func (imm *InMemoryModule) notify(events ...*storage.Event) {
+	if len(events) == 0 {
+		return
+	}
+	imm.watchers.lock.RLock()
+	defer imm.watchers.lock.RUnlock()
+	for _, w := range imm.watchers.watches {
+		for _, event := range events {
+			if event.Matches(w.request) && !w.send(event) {
+				break
+			}
+		}
+	}
+}
+
+// send sends the event without blocking, and closes the watch if its Reply channel is full. It returns false once
+// the watch is closed.
+func (w *watch) send(event *storage.Event) bool {
+	select {
+	case <-w.overflow:
+		return false
+	default:
+	}
+	select {
+	case w.request.Reply <- event:
+		return true
+	default:
+		w.once.Do(func() { close(w.overflow) })
+		return false
+	}
+}
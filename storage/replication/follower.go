@@ -0,0 +1,154 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+	"github.com/jbvmio/modules/storage/inmemory"
+
+	"go.uber.org/zap"
+)
+
+// follow replicates from the primary until the module is stopped, reconnecting after every failure.
+func (module *ReplicatedModule) follow() {
+	defer module.replRunning.Done()
+
+	for {
+		err := module.replicate()
+		select {
+		case <-module.stopChannel:
+			return
+		default:
+		}
+		module.Log.Warn("Replication Interrupted",
+			zap.String("primary", module.primary),
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(module.retryInterval):
+		case <-module.stopChannel:
+			return
+		}
+	}
+}
+
+// replicate connects to the primary and applies the messages it sends, until the connection fails.
+func (module *ReplicatedModule) replicate() error {
+	conn, err := net.DialTimeout("tcp", module.primary, module.heartbeatInterval*3)
+	if err != nil {
+		return err
+	}
+	if !module.track(conn, true) {
+		conn.Close()
+		return nil
+	}
+	defer module.track(conn, false)
+	defer conn.Close()
+
+	module.followLock.Lock()
+	h := hello{Log: module.logID, Seq: module.seq}
+	module.followLock.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(module.heartbeatInterval * 3))
+	if err := json.NewEncoder(conn).Encode(&h); err != nil {
+		return err
+	}
+	module.Log.Info("Following Primary",
+		zap.String("primary", module.primary),
+		zap.String("log", h.Log),
+		zap.Uint64("seq", h.Seq),
+	)
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var msg message
+		conn.SetReadDeadline(time.Now().Add(module.heartbeatInterval * 3))
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+		if err := module.receive(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// receive applies a message from the primary to the data set.
+func (module *ReplicatedModule) receive(msg *message) error {
+	module.followLock.Lock()
+	defer module.followLock.Unlock()
+
+	switch msg.Kind {
+	case kindSnapshot:
+		// Until the snapshot is complete, the data set does not match any sequence of the log
+		module.logID = ""
+		module.seq = 0
+		return module.reset()
+	case kindState:
+		if module.next == nil {
+			return fmt.Errorf("received state outside of a snapshot")
+		}
+		return module.apply(module.next, msg.Changes)
+	case kindSnapshotEnd:
+		if module.next == nil {
+			return fmt.Errorf("received snapshot end outside of a snapshot")
+		}
+		module.swap()
+		module.logID = msg.Log
+		module.seq = msg.Seq
+	case kindChanges:
+		if module.logID == "" || msg.Seq != module.seq+1 {
+			return fmt.Errorf("expected changes from seq %d, received seq %d", module.seq+1, msg.Seq)
+		}
+		// The data set is only replaced by this goroutine, so it can be read without the lock
+		if err := module.apply(module.store, msg.Changes); err != nil {
+			return err
+		}
+		module.seq += uint64(len(msg.Changes))
+	case kindHeartbeat:
+	default:
+		return fmt.Errorf("unknown message kind %q", msg.Kind)
+	}
+	return nil
+}
+
+// apply decodes the changes and applies them to the data set.
+func (module *ReplicatedModule) apply(store *inmemory.InMemoryModule, changes []change) error {
+	events := make([]*storage.Event, 0, len(changes))
+	for i := range changes {
+		event, err := changes[i].decode()
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	store.Apply(events...)
+	return nil
+}
+
+// reset starts a new data set for a snapshot to be applied to, discarding that of any snapshot not completed.
+func (module *ReplicatedModule) reset() error {
+	if module.next != nil {
+		module.next.Stop()
+		module.next = nil
+	}
+	next := module.newStore()
+	if err := next.Start(); err != nil {
+		return err
+	}
+	module.next = next
+	return nil
+}
+
+// swap replaces the data set by that of the completed snapshot. Requests already forwarded to the old data set are
+// handled before it is stopped.
+func (module *ReplicatedModule) swap() {
+	module.storeLock.Lock()
+	old := module.store
+	module.store = module.next
+	module.storeLock.Unlock()
+	module.next = nil
+	old.Stop()
+}
@@ -0,0 +1,138 @@
+package replication
+
+import (
+	"sync"
+
+	"github.com/jbvmio/modules/storage"
+)
+
+// changeLog keeps the last changes recorded by the data set of the primary, numbered by sequence from 1, in a ring.
+type changeLog struct {
+	id string
+
+	lock    sync.Mutex
+	events  []*storage.Event
+	next    uint64
+	changed chan struct{}
+}
+
+func newChangeLog(id string, size int) *changeLog {
+	return &changeLog{
+		id:      id,
+		events:  make([]*storage.Event, size),
+		next:    1,
+		changed: make(chan struct{}),
+	}
+}
+
+// append records the events. It is the journal of the data set of the primary, so it must not block.
+func (l *changeLog) append(events ...*storage.Event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, event := range events {
+		l.events[l.next%uint64(len(l.events))] = event
+		l.next++
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// last returns the sequence of the last change recorded.
+func (l *changeLog) last() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.next - 1
+}
+
+// since returns up to max changes from the sequence from. If there are none yet, it returns a channel which is closed
+// once there are. It returns false if the change at from is no longer kept.
+func (l *changeLog) since(from uint64, max int) ([]*storage.Event, chan struct{}, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	size := uint64(len(l.events))
+	if l.next > size && from < l.next-size {
+		return nil, nil, false
+	}
+	if from >= l.next {
+		return nil, l.changed, true
+	}
+	events := make([]*storage.Event, 0, min(uint64(max), l.next-from))
+	for seq := from; seq < l.next && len(events) < max; seq++ {
+		events = append(events, l.events[seq%size])
+	}
+	return events, nil, true
+}
+
+// Message kinds sent from the primary to followers.
+const (
+	kindSnapshot    = `snapshot`
+	kindState       = `state`
+	kindSnapshotEnd = `snapshot-end`
+	kindChanges     = `changes`
+	kindHeartbeat   = `heartbeat`
+)
+
+// hello is sent by a follower when it connects, with the log and sequence of the last change it applied. Log is empty
+// if the follower has not applied any changes.
+type hello struct {
+	Log string `json:"log,omitempty"`
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// message is sent by the primary to followers, as one line of JSON each.
+//
+// A snapshot is sent as a kindSnapshot message with the log and sequence it was taken at, then kindState messages
+// with the data set, then a kindSnapshotEnd message with the same log and sequence. Changes are sent as kindChanges messages, with the sequence of
+// the first change. A kindHeartbeat message is sent with the sequence of the last change while there are no changes
+// to send.
+type message struct {
+	Kind    string   `json:"kind"`
+	Log     string   `json:"log,omitempty"`
+	Seq     uint64   `json:"seq,omitempty"`
+	Changes []change `json:"changes,omitempty"`
+}
+
+// change is the serialized form of an Event recorded in the log.
+type change struct {
+	Type  storage.EventConstant `json:"type"`
+	Index string                `json:"index,omitempty"`
+	DB    string                `json:"db,omitempty"`
+	Entry string                `json:"entry,omitempty"`
+	Data  *storage.EncodedData  `json:"data,omitempty"`
+}
+
+// encodeChange returns the change for the Event. Only the New Data of the Event is kept.
+func encodeChange(event *storage.Event) (change, error) {
+	c := change{
+		Type:  event.Type,
+		Index: event.Index,
+		DB:    event.DB,
+		Entry: event.Entry,
+	}
+	if event.New != nil {
+		data, err := event.New.Encode()
+		if err != nil {
+			return c, err
+		}
+		c.Data = data
+	}
+	return c, nil
+}
+
+// decode returns the Event for the change.
+func (c *change) decode() (*storage.Event, error) {
+	event := &storage.Event{
+		Type:  c.Type,
+		Index: c.Index,
+		DB:    c.DB,
+		Entry: c.Entry,
+	}
+	if c.Data != nil {
+		data, err := c.Data.Decode()
+		if err != nil {
+			return nil, err
+		}
+		event.New = data
+	}
+	return event, nil
+}
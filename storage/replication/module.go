@@ -0,0 +1,362 @@
+package replication
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jbvmio/modules/coop"
+	"github.com/jbvmio/modules/storage"
+	"github.com/jbvmio/modules/storage/inmemory"
+	"github.com/spf13/viper"
+
+	"go.uber.org/zap"
+)
+
+const (
+	moduleName  = `replicated`
+	moduleClass = `replicated`
+)
+
+// Replication roles.
+const (
+	RolePrimary  = `primary`
+	RoleFollower = `follower`
+)
+
+// ReplicatedModule is a storage module that keeps its data set in an InMemoryModule and replicates it between
+// instances. A primary records every change to its data set in an ordered log, numbered by sequence, and streams the
+// log to followers connected over TCP. A follower applies the log to its own data set, and is read-only: requests
+// which would change the data set are rejected with ErrReadOnly.
+//
+// A follower which connects for the first time, or which has fallen behind the changes kept in the log of the
+// primary, first catches up from a snapshot of the data set of the primary, then continues with the log from the
+// sequence of the snapshot. The snapshot is applied to a new data set, which replaces the current one once complete,
+// so requests are served from the current data set until then. Watches of the replaced data set are closed. Objects
+// are replicated using their registered Codec, see storage.RegisterCodec. The Ring of an Entry written by
+// TypeAppendEntry is replicated with the Codecs of the Objects appended to it.
+//
+// Writes without a Reply, such as TypeSetEntry and TypeDeleteEntry, cannot be answered with ErrReadOnly on a
+// follower, so they are logged and counted in the Rejected field of Status instead.
+type ReplicatedModule struct {
+	// App is a pointer to the application context. This stores the channel to the storage subsystem
+	App *coop.ApplicationContext
+
+	// Log is a logger that has been configured for this module to use. Normally, this means it has been set up with
+	// fields that are appropriate to identify this coordinator
+	Log *zap.Logger
+
+	name              string
+	class             string
+	role              string
+	listen            string
+	primary           string
+	logSize           int
+	heartbeatInterval time.Duration
+	retryInterval     time.Duration
+	queueDepth        int
+
+	requestChannel chan *storage.Request
+	mainRunning    sync.WaitGroup
+	replRunning    sync.WaitGroup
+	stopChannel    chan struct{}
+
+	// storeLock is held for reading while a request is forwarded to the data set, and for writing while it is
+	// replaced by a snapshot.
+	storeLock sync.RWMutex
+	store     *inmemory.InMemoryModule
+	rejected  atomic.Uint64
+
+	// Primary state
+	log      *changeLog
+	listener net.Listener
+
+	// Follower state
+	followLock sync.Mutex
+	logID      string
+	seq        uint64
+	next       *inmemory.InMemoryModule
+
+	connLock sync.Mutex
+	conns    map[net.Conn]struct{}
+
+	quitChannel chan struct{}
+	running     *sync.WaitGroup
+}
+
+// Status is the replication state of a ReplicatedModule.
+type Status struct {
+	// Role is either RolePrimary or RoleFollower.
+	Role string `json:"role"`
+
+	// Log identifies the log of the primary, which changes every time the primary is started.
+	Log string `json:"log"`
+
+	// Seq is the sequence of the last change recorded by the primary, or applied by the follower.
+	Seq uint64 `json:"seq"`
+
+	// Connections is the number of followers connected to the primary, or 1 if the follower is connected.
+	Connections int `json:"connections"`
+
+	// Rejected is the number of writes without a Reply rejected by the follower, as it is read-only.
+	Rejected uint64 `json:"rejected"`
+}
+
+// AssignApplicationContext assigns the underlying ApplicationContext.
+func (module *ReplicatedModule) AssignApplicationContext(app *coop.ApplicationContext) {
+	module.App = app
+}
+
+// ModuleDetails returns the Module class and name.
+func (module *ReplicatedModule) ModuleDetails() (string, string) {
+	return moduleClass, moduleName
+}
+
+// AssignModuleLogger assigns the underlying ApplicationContext.
+func (module *ReplicatedModule) AssignModuleLogger(logger *zap.Logger) {
+	module.Log = logger
+}
+
+// ModuleLogger returns the Modules' underlying Logger.
+func (module *ReplicatedModule) ModuleLogger() *zap.Logger {
+	return module.Log
+}
+
+// Init initializes the Module by setting the name, class and
+// assigning the passed in channel and waitgroup.
+func (module *ReplicatedModule) Init(quitChannel chan struct{}, running *sync.WaitGroup) {
+	module.name = moduleName
+	module.class = moduleClass
+	module.quitChannel = quitChannel
+	module.running = running
+}
+
+// Configure validates the configuration for the module, creates a channel to receive requests on, and configures
+// the InMemoryModule holding the data set from modules.inmemory. The role must be set to either primary or follower.
+//
+// A primary listens for followers on the listen address, 127.0.0.1:7070 by default, and keeps the last log-size
+// changes, 10000 by default, for followers to catch up from. A heartbeat is sent to followers every
+// heartbeat-interval seconds, 1 by default, while there are no changes to send.
+//
+// A follower connects to the primary address. If the connection fails, or no message is received within three
+// heartbeat intervals, it reconnects after retry-interval seconds, 1 by default.
+func (module *ReplicatedModule) Configure() {
+	module.Log.Info("configuring replicated module")
+	configRoot := `modules.replicated`
+
+	viper.SetDefault(configRoot+".listen", "127.0.0.1:7070")
+	viper.SetDefault(configRoot+".log-size", 10000)
+	viper.SetDefault(configRoot+".heartbeat-interval", 1)
+	viper.SetDefault(configRoot+".retry-interval", 1)
+	viper.SetDefault(configRoot+".queue-depth", 1)
+	module.role = viper.GetString(configRoot + ".role")
+	module.listen = viper.GetString(configRoot + ".listen")
+	module.primary = viper.GetString(configRoot + ".primary")
+	module.logSize = viper.GetInt(configRoot + ".log-size")
+	module.heartbeatInterval = time.Duration(viper.GetInt(configRoot+".heartbeat-interval")) * time.Second
+	module.retryInterval = time.Duration(viper.GetInt(configRoot+".retry-interval")) * time.Second
+	module.queueDepth = viper.GetInt(configRoot + ".queue-depth")
+
+	switch module.role {
+	case RolePrimary:
+		if module.logSize < 1 {
+			panic("replicated module log-size must be at least 1")
+		}
+	case RoleFollower:
+		if module.primary == "" {
+			panic("replicated module primary must be set for a follower")
+		}
+	default:
+		panic("replicated module role must be either primary or follower")
+	}
+	if module.heartbeatInterval < time.Second {
+		panic("replicated module heartbeat-interval must be at least 1 second")
+	}
+	if module.retryInterval < time.Second {
+		panic("replicated module retry-interval must be at least 1 second")
+	}
+
+	module.requestChannel = make(chan *storage.Request, module.queueDepth)
+	module.mainRunning = sync.WaitGroup{}
+	module.replRunning = sync.WaitGroup{}
+	module.stopChannel = make(chan struct{})
+	module.conns = make(map[net.Conn]struct{})
+
+	module.store = module.newStore()
+	if module.role == RolePrimary {
+		module.log = newChangeLog(strconv.FormatInt(time.Now().UnixNano(), 36), module.logSize)
+		module.store.SetJournal(module.log.append)
+	}
+}
+
+// newStore returns a configured InMemoryModule to hold the data set.
+func (module *ReplicatedModule) newStore() *inmemory.InMemoryModule {
+	store := &inmemory.InMemoryModule{}
+	store.Init(module.quitChannel, &sync.WaitGroup{})
+	store.AssignApplicationContext(module.App)
+	store.AssignModuleLogger(module.Log.With(zap.String("store", "inmemory")))
+	store.Configure()
+	return store
+}
+
+// Start starts the InMemoryModule holding the data set, then either listens for followers or starts following the
+// primary, and starts the main loop which forwards requests to the data set.
+func (module *ReplicatedModule) Start() error {
+	module.Log.Info("starting",
+		zap.String("role", module.role),
+	)
+	if err := module.store.Start(); err != nil {
+		return err
+	}
+
+	switch module.role {
+	case RolePrimary:
+		listener, err := net.Listen("tcp", module.listen)
+		if err != nil {
+			module.store.Stop()
+			return err
+		}
+		module.listener = listener
+		module.Log.Info("listening for followers",
+			zap.String("address", listener.Addr().String()),
+		)
+		module.replRunning.Add(1)
+		go module.accept()
+	case RoleFollower:
+		module.replRunning.Add(1)
+		go module.follow()
+	}
+
+	module.mainRunning.Add(1)
+	go module.mainLoop()
+	return nil
+}
+
+// Stop closes the incoming request channel, which will close the main loop, then closes every replication
+// connection and stops the data set.
+func (module *ReplicatedModule) Stop() error {
+	module.Log.Info("stopping")
+
+	close(module.stopChannel)
+	close(module.requestChannel)
+	module.mainRunning.Wait()
+
+	if module.listener != nil {
+		module.listener.Close()
+	}
+	module.connLock.Lock()
+	for conn := range module.conns {
+		conn.Close()
+	}
+	module.connLock.Unlock()
+	module.replRunning.Wait()
+
+	// A snapshot which was not completed is discarded
+	if module.next != nil {
+		module.next.Stop()
+		module.next = nil
+	}
+	return module.store.Stop()
+}
+
+func (module *ReplicatedModule) mainLoop() {
+	defer module.mainRunning.Done()
+
+	for r := range module.requestChannel {
+		if module.role == RoleFollower && writes(r) {
+			err := storage.Errorf(storage.CodeReadOnly, "%v on a follower", r.RequestType)
+			if r.Reply == nil {
+				module.rejected.Add(1)
+				module.Log.Error("Rejected Write",
+					zap.String("request", r.RequestType.String()),
+					zap.String("index", r.Index),
+					zap.String("db", r.DB),
+					zap.String("entry", r.Entry),
+					zap.Error(err),
+				)
+				continue
+			}
+			storage.Reject(r, err)
+			continue
+		}
+		module.storeLock.RLock()
+		module.store.GetCommunicationChannel() <- r
+		module.storeLock.RUnlock()
+	}
+}
+
+// writes returns true if the request could change the data set. Secondary indexes are not replicated, so they can be
+// set on followers.
+func writes(r *storage.Request) bool {
+	switch r.RequestType {
+	case storage.TypeSetIndex, storage.TypeSetEntry, storage.TypeDeleteEntry, storage.TypeCompareAndSet,
+		storage.TypeDeleteDB, storage.TypeDeleteIndex, storage.TypeIncrement, storage.TypeDecrement,
+		storage.TypeTransaction, storage.TypeAppendEntry:
+		return true
+	case storage.TypeBatch:
+		for _, op := range r.Operations {
+			if writes(op) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// track adds or removes a replication connection to be closed when the module is stopped. It returns false if the
+// module is already stopped.
+func (module *ReplicatedModule) track(conn net.Conn, add bool) bool {
+	module.connLock.Lock()
+	defer module.connLock.Unlock()
+	if !add {
+		delete(module.conns, conn)
+		return true
+	}
+	select {
+	case <-module.stopChannel:
+		return false
+	default:
+	}
+	module.conns[conn] = struct{}{}
+	return true
+}
+
+// Status returns the replication state of the module.
+func (module *ReplicatedModule) Status() Status {
+	module.connLock.Lock()
+	connections := len(module.conns)
+	module.connLock.Unlock()
+
+	if module.role == RolePrimary {
+		return Status{
+			Role:        module.role,
+			Log:         module.log.id,
+			Seq:         module.log.last(),
+			Connections: connections,
+		}
+	}
+	module.followLock.Lock()
+	defer module.followLock.Unlock()
+	return Status{
+		Role:        module.role,
+		Log:         module.logID,
+		Seq:         module.seq,
+		Connections: connections,
+		Rejected:    module.rejected.Load(),
+	}
+}
+
+// Addr returns the address the primary listens for followers on, or nil for a follower.
+func (module *ReplicatedModule) Addr() net.Addr {
+	if module.listener == nil {
+		return nil
+	}
+	return module.listener.Addr()
+}
+
+// GetCommunicationChannel returns the RequestChannel that has been setup for this module.
+func (module *ReplicatedModule) GetCommunicationChannel() chan *storage.Request {
+	return module.requestChannel
+}
@@ -0,0 +1,171 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+
+	"go.uber.org/zap"
+)
+
+// maxChanges is the most changes sent in a single message.
+const maxChanges = 256
+
+// accept serves followers until the listener is closed.
+func (module *ReplicatedModule) accept() {
+	defer module.replRunning.Done()
+
+	for {
+		conn, err := module.listener.Accept()
+		if err != nil {
+			select {
+			case <-module.stopChannel:
+			default:
+				module.Log.Error("Error Accepting Follower",
+					zap.Error(err),
+				)
+			}
+			return
+		}
+		if !module.track(conn, true) {
+			conn.Close()
+			return
+		}
+		module.replRunning.Add(1)
+		go module.serve(conn)
+	}
+}
+
+// serve streams the log to a follower, starting with a snapshot if the follower cannot continue from the log.
+func (module *ReplicatedModule) serve(conn net.Conn) {
+	defer module.replRunning.Done()
+	defer module.track(conn, false)
+	defer conn.Close()
+	followerLogger := module.Log.With(
+		zap.String("follower", conn.RemoteAddr().String()),
+	)
+
+	var h hello
+	conn.SetReadDeadline(time.Now().Add(module.heartbeatInterval * 3))
+	if err := json.NewDecoder(conn).Decode(&h); err != nil {
+		followerLogger.Error("Error Reading Follower Hello",
+			zap.Error(err),
+		)
+		return
+	}
+	followerLogger.Info("Follower Connected",
+		zap.String("log", h.Log),
+		zap.Uint64("seq", h.Seq),
+	)
+
+	writer := bufio.NewWriter(conn)
+	stream := &stream{
+		conn:    conn,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+		timeout: module.heartbeatInterval * 3,
+		logger:  followerLogger,
+	}
+	// A follower of another log, such as of a previous run of the primary, always starts from a snapshot
+	seq, snapshot := h.Seq, h.Log != module.log.id
+	heartbeat := time.NewTicker(module.heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		events, changed, ok := module.log.since(seq+1, maxChanges)
+		var err error
+		switch {
+		case snapshot || !ok:
+			seq, err = module.sendSnapshot(stream)
+			snapshot = false
+		case len(events) > 0:
+			err = stream.sendChanges(seq+1, events)
+			seq += uint64(len(events))
+		default:
+			select {
+			case <-changed:
+			case <-heartbeat.C:
+				err = stream.send(&message{Kind: kindHeartbeat, Seq: seq})
+			case <-module.stopChannel:
+				return
+			}
+		}
+		if err != nil {
+			followerLogger.Info("Follower Disconnected",
+				zap.Error(err),
+			)
+			return
+		}
+	}
+}
+
+// sendSnapshot sends a snapshot of the data set and returns the sequence of the last change it includes.
+func (module *ReplicatedModule) sendSnapshot(stream *stream) (uint64, error) {
+	var seq uint64
+	events := module.store.State(func() {
+		seq = module.log.last()
+	})
+	stream.logger.Info("Sending Snapshot",
+		zap.Uint64("seq", seq),
+		zap.Int("changes", len(events)),
+	)
+	if err := stream.send(&message{Kind: kindSnapshot, Log: module.log.id, Seq: seq}); err != nil {
+		return 0, err
+	}
+	for start := 0; start < len(events); start += maxChanges {
+		end := min(start+maxChanges, len(events))
+		if err := stream.sendChanges(0, events[start:end]); err != nil {
+			return 0, err
+		}
+	}
+	return seq, stream.send(&message{Kind: kindSnapshotEnd, Log: module.log.id, Seq: seq})
+}
+
+// stream writes messages to a follower.
+type stream struct {
+	conn    net.Conn
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	timeout time.Duration
+	logger  *zap.Logger
+}
+
+// send writes the message to the follower.
+func (s *stream) send(msg *message) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if err := s.encoder.Encode(msg); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+// sendChanges writes the events as a kindChanges message starting at the sequence seq, or as a kindState message of a
+// snapshot if seq is 0. An Entry with an Object which cannot be encoded is sent as deleted, so the follower does not
+// keep a stale value, and an error is logged.
+func (s *stream) sendChanges(seq uint64, events []*storage.Event) error {
+	msg := &message{
+		Kind:    kindChanges,
+		Seq:     seq,
+		Changes: make([]change, 0, len(events)),
+	}
+	if seq == 0 {
+		msg.Kind = kindState
+	}
+	for _, event := range events {
+		c, err := encodeChange(event)
+		if err != nil {
+			s.logger.Error("Error Encoding Change",
+				zap.String("index", event.Index),
+				zap.String("db", event.DB),
+				zap.String("entry", event.Entry),
+				zap.Error(err),
+			)
+			// Keep the sequence of the changes which follow
+			c = change{Type: storage.EventDelete, Index: event.Index, DB: event.DB, Entry: event.Entry}
+		}
+		msg.Changes = append(msg.Changes, c)
+	}
+	return s.send(msg)
+}
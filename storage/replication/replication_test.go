@@ -0,0 +1,176 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+	"github.com/spf13/viper"
+
+	"go.uber.org/zap"
+)
+
+type testObject struct {
+	Name string
+}
+
+func (o testObject) ID() string {
+	return o.Name
+}
+
+func newTestModule(t *testing.T, role, listen, primary string) *ReplicatedModule {
+	t.Helper()
+	viper.Set("modules.replicated.role", role)
+	viper.Set("modules.replicated.listen", listen)
+	viper.Set("modules.replicated.primary", primary)
+	viper.Set("modules.replicated.log-size", 8)
+	module := &ReplicatedModule{}
+	module.Init(make(chan struct{}), &sync.WaitGroup{})
+	module.AssignModuleLogger(zap.NewNop())
+	module.Configure()
+	if err := module.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return module
+}
+
+func set(module *ReplicatedModule, db, entry, name string) {
+	module.GetCommunicationChannel() <- &storage.Request{
+		RequestType: storage.TypeSetEntry,
+		Index:       "test",
+		DB:          db,
+		Entry:       entry,
+		Object:      testObject{Name: name},
+	}
+}
+
+func get(t *testing.T, module *ReplicatedModule, db, entry string) (string, bool) {
+	t.Helper()
+	r, err := storage.BuildRequest().SetRequestType(storage.TypeFetchEntry).SetIndex("test").SetDB(db).SetEntry(entry).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	module.GetCommunicationChannel() <- r
+	data, ok := (<-r.Reply).(*storage.Data)
+	if !ok {
+		return "", false
+	}
+	return data.Object.(testObject).Name, true
+}
+
+func appendPoint(module *ReplicatedModule, db, entry, name string) {
+	module.GetCommunicationChannel() <- &storage.Request{
+		RequestType: storage.TypeAppendEntry,
+		Index:       "test",
+		DB:          db,
+		Entry:       entry,
+		Object:      testObject{Name: name},
+	}
+}
+
+// getRing returns the names of the points of the Ring stored in the Entry.
+func getRing(t *testing.T, module *ReplicatedModule, db, entry string) []string {
+	t.Helper()
+	r, err := storage.BuildRequest().SetRequestType(storage.TypeFetchEntry).SetIndex("test").SetDB(db).SetEntry(entry).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	module.GetCommunicationChannel() <- r
+	data, ok := (<-r.Reply).(*storage.Data)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, point := range data.Object.(*storage.Ring).Points {
+		names = append(names, point.Object.(testObject).Name)
+	}
+	return names
+}
+
+// waitFollower waits for the follower to apply every change recorded by the primary. The writes to wait for must be
+// handled by the primary first, such as by fetching the last write of each DB.
+func waitFollower(t *testing.T, primary, follower *ReplicatedModule) {
+	t.Helper()
+	want := primary.Status()
+	for i := 0; i < 500; i++ {
+		if status := follower.Status(); status.Log == want.Log && status.Seq >= want.Seq {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("follower at %+v, primary at %+v", follower.Status(), want)
+}
+
+func TestReplication(t *testing.T) {
+	storage.RegisterJSON("replication.testObject", testObject{})
+	primary := newTestModule(t, RolePrimary, "127.0.0.1:0", "")
+	for n := 0; n < 20; n++ {
+		set(primary, "a", fmt.Sprint(n), fmt.Sprint(n))
+	}
+	appendPoint(primary, "a", "ring", "p1")
+	appendPoint(primary, "a", "ring", "p2")
+	get(t, primary, "a", "19")
+
+	// A new follower catches up from a snapshot
+	follower := newTestModule(t, RoleFollower, "", primary.Addr().String())
+	waitFollower(t, primary, follower)
+	if name, ok := get(t, follower, "a", "19"); !ok || name != "19" {
+		t.Fatalf("snapshot: got %q, %v", name, ok)
+	}
+	if points := getRing(t, follower, "a", "ring"); fmt.Sprint(points) != "[p1 p2]" {
+		t.Fatalf("snapshot ring: got %v", points)
+	}
+
+	// Then continues from the log
+	set(primary, "a", "0", "changed")
+	set(primary, "b", "x", "x")
+	appendPoint(primary, "a", "ring", "p3")
+	get(t, primary, "a", "0")
+	get(t, primary, "b", "x")
+	waitFollower(t, primary, follower)
+	if name, _ := get(t, follower, "a", "0"); name != "changed" {
+		t.Fatalf("log: got %q", name)
+	}
+	if name, _ := get(t, follower, "b", "x"); name != "x" {
+		t.Fatalf("log: got %q", name)
+	}
+	if points := getRing(t, follower, "a", "ring"); fmt.Sprint(points) != "[p1 p2 p3]" {
+		t.Fatalf("log ring: got %v", points)
+	}
+
+	// Writes are rejected with ErrReadOnly, or counted if they have no Reply
+	r, err := storage.BuildRequest().SetRequestType(storage.TypeIncrement).SetIndex("test").SetDB("a").SetEntry("n").SetObject(storage.Int64(1)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	follower.GetCommunicationChannel() <- r
+	if err, _ := (<-r.Reply).(error); !errors.Is(err, storage.ErrReadOnly) {
+		t.Fatalf("increment on follower: got %v", err)
+	}
+	set(follower, "a", "1", "follower")
+	if name, _ := get(t, follower, "a", "1"); name != "1" {
+		t.Fatalf("set on follower: got %q", name)
+	}
+	if status := follower.Status(); status.Rejected != 1 {
+		t.Fatalf("rejected: got %d", status.Rejected)
+	}
+
+	// A follower of a restarted primary catches up from a new snapshot, which replaces its data set
+	addr := primary.Addr().String()
+	primary.Stop()
+	primary = newTestModule(t, RolePrimary, addr, "")
+	defer primary.Stop()
+	defer follower.Stop()
+	set(primary, "c", "x", "c")
+	get(t, primary, "c", "x")
+	waitFollower(t, primary, follower)
+	if _, ok := get(t, follower, "a", "0"); ok {
+		t.Fatal("entry of the old primary kept after snapshot")
+	}
+	if name, _ := get(t, follower, "c", "x"); name != "c" {
+		t.Fatalf("second snapshot: got %q", name)
+	}
+}
@@ -0,0 +1,192 @@
+package sharded
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jbvmio/modules/coop"
+	"github.com/jbvmio/modules/storage"
+	"github.com/jbvmio/modules/storage/inmemory"
+	"github.com/spf13/viper"
+
+	"go.uber.org/zap"
+)
+
+const (
+	moduleName  = `sharded`
+	moduleClass = `sharded`
+)
+
+// ShardedModule is a storage module that spreads the data set across a number of independent InMemoryModule shards,
+// each with its own main loop and workers. Requests for a DB are routed to a single shard by a consistent hash of the
+// Index and DB names, so all requests for a DB are handled by the same shard. Requests for several DBs are fanned out
+// to the shards and the replies merged. TypeTransaction requests must only hold operations for a single DB, so they
+// are applied atomically by its shard, and are otherwise rejected with ErrInvalidRequest.
+//
+// The number of shards can be changed while running with Reshard. The consistent hash only moves the DBs whose shard
+// changes, and they are moved whole, keeping their entries, versions, TTLs and secondary indexes.
+//
+// The memory limits configured for the shards in modules.inmemory apply to the entries of all shards together, as
+// the shards share a single Limiter.
+type ShardedModule struct {
+	// App is a pointer to the application context. This stores the channel to the storage subsystem
+	App *coop.ApplicationContext
+
+	// Log is a logger that has been configured for this module to use. Normally, this means it has been set up with
+	// fields that are appropriate to identify this coordinator
+	Log *zap.Logger
+
+	name       string
+	class      string
+	numShards  int
+	queueDepth int
+
+	requestChannel chan *storage.Request
+	mainRunning    sync.WaitGroup
+	stopChannel    chan struct{}
+
+	// lock is held for reading while a request is routed, and for writing while resharding.
+	lock    sync.RWMutex
+	shards  []*inmemory.InMemoryModule
+	limiter *inmemory.Limiter
+
+	watchLock sync.Mutex
+	watches   map[*shardWatch]struct{}
+
+	quitChannel chan struct{}
+	running     *sync.WaitGroup
+}
+
+// AssignApplicationContext assigns the underlying ApplicationContext.
+func (module *ShardedModule) AssignApplicationContext(app *coop.ApplicationContext) {
+	module.App = app
+}
+
+// ModuleDetails returns the Module class and name.
+func (module *ShardedModule) ModuleDetails() (string, string) {
+	return moduleClass, moduleName
+}
+
+// AssignModuleLogger assigns the underlying ApplicationContext.
+func (module *ShardedModule) AssignModuleLogger(logger *zap.Logger) {
+	module.Log = logger
+}
+
+// ModuleLogger returns the Modules' underlying Logger.
+func (module *ShardedModule) ModuleLogger() *zap.Logger {
+	return module.Log
+}
+
+// Init initializes the Module by setting the name, class and
+// assigning the passed in channel and waitgroup.
+func (module *ShardedModule) Init(quitChannel chan struct{}, running *sync.WaitGroup) {
+	module.name = moduleName
+	module.class = moduleClass
+	module.quitChannel = quitChannel
+	module.running = running
+}
+
+// Configure validates the configuration for the module and creates a channel to receive requests on. If no shard
+// count is set, a default of 4 shards is used. Each shard is an InMemoryModule configured from modules.inmemory, so
+// settings such as the worker count apply to every shard.
+func (module *ShardedModule) Configure() {
+	module.Log.Info("configuring sharded module")
+	configRoot := `modules.sharded`
+
+	viper.SetDefault(configRoot+".shards", 4)
+	viper.SetDefault(configRoot+".queue-depth", 1)
+	module.numShards = viper.GetInt(configRoot + ".shards")
+	module.queueDepth = viper.GetInt(configRoot + ".queue-depth")
+
+	if module.numShards < 1 {
+		panic("sharded module shards must be at least 1")
+	}
+
+	module.requestChannel = make(chan *storage.Request, module.queueDepth)
+	module.mainRunning = sync.WaitGroup{}
+	module.stopChannel = make(chan struct{})
+	module.watches = make(map[*shardWatch]struct{})
+}
+
+// Start starts the configured number of shards, then the main loop which routes requests to them.
+func (module *ShardedModule) Start() error {
+	module.Log.Info("starting")
+
+	module.shards = make([]*inmemory.InMemoryModule, 0, module.numShards)
+	for i := 0; i < module.numShards; i++ {
+		shard, err := module.startShard(i)
+		if err != nil {
+			for _, shard := range module.shards {
+				shard.Stop()
+			}
+			return err
+		}
+		module.shards = append(module.shards, shard)
+	}
+
+	module.mainRunning.Add(1)
+	go module.mainLoop()
+	return nil
+}
+
+// Stop closes the incoming request channel, which will close the main loop, then stops every shard.
+func (module *ShardedModule) Stop() error {
+	module.Log.Info("stopping")
+
+	close(module.stopChannel)
+	close(module.requestChannel)
+	module.mainRunning.Wait()
+
+	module.lock.Lock()
+	defer module.lock.Unlock()
+	for _, shard := range module.shards {
+		shard.Stop()
+	}
+	return nil
+}
+
+// startShard configures and starts a new shard.
+func (module *ShardedModule) startShard(i int) (*inmemory.InMemoryModule, error) {
+	shard := &inmemory.InMemoryModule{}
+	shard.Init(module.quitChannel, &sync.WaitGroup{})
+	shard.AssignApplicationContext(module.App)
+	shard.AssignModuleLogger(module.Log.With(zap.Int("shard", i)))
+	shard.Configure()
+	if module.limiter == nil {
+		module.limiter = shard.Limiter()
+	}
+	shard.SetLimiter(module.limiter)
+	if err := shard.Start(); err != nil {
+		return nil, fmt.Errorf("starting shard %d: %w", i, err)
+	}
+	return shard, nil
+}
+
+func (module *ShardedModule) mainLoop() {
+	defer module.mainRunning.Done()
+
+	for r := range module.requestChannel {
+		module.lock.RLock()
+		module.route(r)
+		module.lock.RUnlock()
+	}
+}
+
+// SetLimiter sets the Limiter shared by the shards, such as the Limiter of another module, so the memory limits apply
+// to the entries of both. It must be set after the module is configured and before it is started. A nil Limiter shares
+// the limits configured for the shards.
+func (module *ShardedModule) SetLimiter(l *inmemory.Limiter) {
+	module.limiter = l
+}
+
+// Shards returns the current number of shards.
+func (module *ShardedModule) Shards() int {
+	module.lock.RLock()
+	defer module.lock.RUnlock()
+	return len(module.shards)
+}
+
+// GetCommunicationChannel returns the RequestChannel that has been setup for this module.
+func (module *ShardedModule) GetCommunicationChannel() chan *storage.Request {
+	return module.requestChannel
+}
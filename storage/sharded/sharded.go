@@ -0,0 +1,365 @@
+package sharded
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/jbvmio/modules/storage"
+	"github.com/jbvmio/modules/storage/inmemory"
+
+	"go.uber.org/zap"
+)
+
+// jumpHash returns the bucket for the key out of the given number of buckets, using the jump consistent hash of
+// Lamping and Veach. When the number of buckets grows from n to n+1, only 1/(n+1) of the keys move, all of them to
+// the new bucket.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// shardFor returns the shard for the DB of the Index, out of the given number of shards.
+func shardFor(index, db string, shards int) int {
+	return jumpHash(xxhash.ChecksumString64(index+db), shards)
+}
+
+// route sends the request to the shards. The lock must be held for reading by the caller.
+func (module *ShardedModule) route(r *storage.Request) {
+	switch r.RequestType {
+	case storage.TypeSetIndex, storage.TypeDeleteIndex:
+		// Every shard holds every Index
+		for _, shard := range module.shards {
+			forward := *r
+			shard.GetCommunicationChannel() <- &forward
+		}
+	case storage.TypeFetchIndexes, storage.TypeFetchDatabases:
+		module.fetchList(r)
+	case storage.TypeWatch:
+		module.addWatch(r)
+	case storage.TypeBatch:
+		split := storage.SplitBatch(r, func(op *storage.Request) int {
+			return shardFor(op.Index, op.DB, len(module.shards))
+		})
+		if len(split.Targets) == 1 {
+			module.shards[split.Targets[0]].GetCommunicationChannel() <- r
+			return
+		}
+		for n, i := range split.Targets {
+			module.shards[i].GetCommunicationChannel() <- split.Requests[n]
+		}
+		go split.Merge()
+	case storage.TypeTransaction:
+		// A transaction can only be applied atomically by a single shard. Limiting it to a single DB, rather than to
+		// a single shard, keeps the transactions which are accepted the same for any number of shards.
+		shard := 0
+		if len(r.Operations) > 0 {
+			first := r.Operations[0]
+			for _, op := range r.Operations[1:] {
+				if op.Index != first.Index || op.DB != first.DB {
+					err := storage.Errorf(storage.CodeInvalidRequest, "%v operations span DBs", r.RequestType)
+					storage.Reject(r, err)
+					return
+				}
+			}
+			shard = shardFor(first.Index, first.DB, len(module.shards))
+		}
+		module.shards[shard].GetCommunicationChannel() <- r
+	case storage.TypeSnapshot, storage.TypeReleaseSnapshot:
+		// A snapshot cannot be consistent across shards
+		err := storage.Errorf(storage.CodeInvalidRequest, "%v is not supported by the sharded module", r.RequestType)
+		storage.Reject(r, err)
+	default:
+		module.shards[shardFor(r.Index, r.DB, len(module.shards))].GetCommunicationChannel() <- r
+	}
+}
+
+// fetchList sends a TypeFetchIndexes or TypeFetchDatabases request to every shard and replies with the merged list.
+// A shard without the Index replies with an error, which is only returned if every shard does.
+func (module *ShardedModule) fetchList(r *storage.Request) {
+	if r.Snapshot != "" {
+		storage.Reject(r, storage.Errorf(storage.CodeUnknownSnapshot, "%v", r.Snapshot))
+		return
+	}
+	replies := make([]chan interface{}, 0, len(module.shards))
+	for _, shard := range module.shards {
+		forward := *r
+		forward.Reply = make(chan interface{}, 1)
+		shard.GetCommunicationChannel() <- &forward
+		replies = append(replies, forward.Reply)
+	}
+	go func() {
+		defer close(r.Reply)
+		seen := make(map[string]bool)
+		list := []string{}
+		var err error
+		var found bool
+		for _, replyChannel := range replies {
+			for reply := range replyChannel {
+				switch reply := reply.(type) {
+				case []string:
+					found = true
+					for _, name := range reply {
+						if !seen[name] {
+							seen[name] = true
+							list = append(list, name)
+						}
+					}
+				case error:
+					if err == nil {
+						err = reply
+					}
+				}
+			}
+		}
+		if err != nil && !found {
+			r.Respond(err)
+			return
+		}
+		r.Respond(list)
+	}()
+}
+
+// shardWatch is a TypeWatch request fanned out to every shard, since resharding can move any DB to another shard.
+// Events from every shard are forwarded to the Reply channel of the request, which is closed once the watch is
+// cancelled, the module is stopped or a shard closes its watch because it overflowed, and every shard has closed its
+// watch.
+type shardWatch struct {
+	request *storage.Request
+	running sync.WaitGroup
+
+	// overflow is closed once a shard closes its watch without it being removed.
+	overflow chan struct{}
+	once     sync.Once
+
+	lock   sync.Mutex
+	closed bool
+	done   map[*inmemory.InMemoryModule]chan struct{}
+}
+
+// addWatch fans the TypeWatch request out to every shard. The lock must be held by the caller.
+func (module *ShardedModule) addWatch(r *storage.Request) {
+	watch := &shardWatch{
+		request:  r,
+		overflow: make(chan struct{}),
+		done:     make(map[*inmemory.InMemoryModule]chan struct{}),
+	}
+	for _, shard := range module.shards {
+		watch.add(shard)
+	}
+	module.watchLock.Lock()
+	module.watches[watch] = struct{}{}
+	module.watchLock.Unlock()
+
+	go func() {
+		select {
+		case <-r.Done:
+		case <-r.Context().Done():
+		case <-watch.overflow:
+		case <-module.stopChannel:
+		}
+		module.watchLock.Lock()
+		delete(module.watches, watch)
+		module.watchLock.Unlock()
+
+		watch.lock.Lock()
+		watch.closed = true
+		for _, done := range watch.done {
+			close(done)
+		}
+		watch.done = nil
+		watch.lock.Unlock()
+
+		watch.running.Wait()
+		close(r.Reply)
+	}()
+}
+
+// add sends the watch to the shard and forwards its events.
+func (watch *shardWatch) add(shard *inmemory.InMemoryModule) {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+	if watch.closed {
+		return
+	}
+	forward := *watch.request
+	forward.Reply = make(chan interface{}, cap(watch.request.Reply))
+	forward.Done = make(chan struct{})
+	watch.done[shard] = forward.Done
+	watch.running.Add(1)
+	shard.GetCommunicationChannel() <- &forward
+
+	go func() {
+		defer watch.running.Done()
+		for event := range forward.Reply {
+			select {
+			case watch.request.Reply <- event:
+			case <-watch.request.Done:
+			case <-watch.request.Context().Done():
+			}
+		}
+		// The shard closed its watch without it being removed, so events were missed
+		watch.lock.Lock()
+		removed := watch.closed || watch.done[shard] != forward.Done
+		watch.lock.Unlock()
+		if !removed {
+			watch.once.Do(func() { close(watch.overflow) })
+		}
+	}()
+}
+
+// remove cancels the watch on the shard.
+func (watch *shardWatch) remove(shard *inmemory.InMemoryModule) {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+	if done, ok := watch.done[shard]; ok {
+		close(done)
+		delete(watch.done, shard)
+	}
+}
+
+// Reshard changes the number of shards while the module is running. Requests are held while the DBs whose shard
+// changes are moved to their new shard, so no request sees a DB in the wrong shard. Moving a DB only moves a reference
+// to it, but every shard is flushed first, so Reshard waits for the requests already sent to the shards. Every DB to
+// move is detached before any is attached to its new shard, so if one cannot be detached, the DBs already detached
+// are attached back, the new shards are stopped and the module is left as it was. The secondary indexes declared for
+// a DB are moved to its new shard with it, whether or not the DB exists.
+func (module *ShardedModule) Reshard(n int) error {
+	if n < 1 {
+		return errors.New("sharded module shards must be at least 1")
+	}
+	module.lock.Lock()
+	defer module.lock.Unlock()
+
+	old := module.shards
+	if n == len(old) {
+		return nil
+	}
+	module.Log.Info("resharding",
+		zap.Int("from", len(old)),
+		zap.Int("to", n),
+	)
+
+	shards := make([]*inmemory.InMemoryModule, 0, n)
+	if n < len(old) {
+		shards = append(shards, old[:n]...)
+	} else {
+		shards = append(shards, old...)
+		for i := len(old); i < n; i++ {
+			shard, err := module.startShard(i)
+			if err != nil {
+				stopShards(shards[len(old):])
+				return err
+			}
+			shards = append(shards, shard)
+		}
+	}
+
+	// Every request routed with the old number of shards must be handled before its DB is moved.
+	for _, shard := range old {
+		shard.Flush()
+	}
+
+	var moves []move
+	indexes := make(map[string]bool)
+	for i, shard := range old {
+		for index, dbs := range shard.Databases() {
+			indexes[index] = true
+			for _, db := range dbs {
+				target := shardFor(index, db, n)
+				if target == i {
+					continue
+				}
+				database, err := detachDB(shard, index, db)
+				if err != nil {
+					for _, m := range moves {
+						old[m.from].AttachDB(m.index, m.db, m.database)
+					}
+					stopShards(shards[min(len(old), n):])
+					return fmt.Errorf("moving db %v of index %v: %w", db, index, err)
+				}
+				moves = append(moves, move{index: index, db: db, database: database, from: i, to: target})
+			}
+		}
+	}
+	for _, m := range moves {
+		shards[m.to].AttachDB(m.index, m.db, m.database)
+	}
+	for i, shard := range old {
+		for index, dbs := range shard.Declarations() {
+			for db, declared := range dbs {
+				target := shardFor(index, db, n)
+				if target == i {
+					continue
+				}
+				for name, extract := range declared {
+					shards[target].DeclareSecondaryIndex(index, db, name, extract)
+					shard.DeclareSecondaryIndex(index, db, name, nil)
+				}
+			}
+		}
+	}
+	// Every shard holds every Index
+	for _, shard := range shards {
+		have := shard.Databases()
+		for index := range indexes {
+			if _, ok := have[index]; !ok {
+				shard.GetCommunicationChannel() <- &storage.Request{
+					RequestType: storage.TypeSetIndex,
+					Index:       index,
+				}
+			}
+		}
+	}
+
+	added, removed := shards[min(len(old), n):], old[min(len(old), n):]
+	module.watchLock.Lock()
+	for watch := range module.watches {
+		for _, shard := range added {
+			watch.add(shard)
+		}
+		for _, shard := range removed {
+			watch.remove(shard)
+		}
+	}
+	module.watchLock.Unlock()
+	// The watches and Indexes of the new shards must be added before any request is routed to them
+	for _, shard := range added {
+		shard.Flush()
+	}
+
+	for _, shard := range removed {
+		shard.Stop()
+	}
+	module.shards = shards
+	module.Log.Info("resharded",
+		zap.Int("shards", n),
+		zap.Int("moved_dbs", len(moves)),
+	)
+	return nil
+}
+
+// detachDB detaches a DB from its shard for Reshard. It is replaced by tests to fail a move.
+var detachDB = (*inmemory.InMemoryModule).DetachDB
+
+// move is a DB detached from its old shard by Reshard, to be attached to its new shard.
+type move struct {
+	index    string
+	db       string
+	database *inmemory.Database
+	from     int
+	to       int
+}
+
+// stopShards stops the shards started by a Reshard which failed.
+func stopShards(shards []*inmemory.InMemoryModule) {
+	for _, shard := range shards {
+		shard.Stop()
+	}
+}
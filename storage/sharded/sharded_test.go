@@ -0,0 +1,192 @@
+package sharded
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+	"github.com/jbvmio/modules/storage/inmemory"
+	"github.com/spf13/viper"
+
+	"go.uber.org/zap"
+)
+
+type testObject struct {
+	Name string
+}
+
+func (o testObject) ID() string {
+	return o.Name
+}
+
+func newTestModule(t *testing.T, shards int) *ShardedModule {
+	t.Helper()
+	viper.Set("modules.sharded.shards", shards)
+	module := &ShardedModule{}
+	module.Init(make(chan struct{}), &sync.WaitGroup{})
+	module.AssignModuleLogger(zap.NewNop())
+	module.Configure()
+	if err := module.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return module
+}
+
+func send(t *testing.T, module *ShardedModule, builder *storage.RequestBuilder) *storage.Request {
+	t.Helper()
+	r, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	module.GetCommunicationChannel() <- r
+	return r
+}
+
+func set(t *testing.T, module *ShardedModule, db, entry, name string) {
+	t.Helper()
+	send(t, module, storage.BuildRequest().SetRequestType(storage.TypeSetEntry).SetIndex("test").SetDB(db).SetEntry(entry).SetObject(testObject{Name: name}))
+}
+
+func get(t *testing.T, module *ShardedModule, db, entry string) (string, bool) {
+	t.Helper()
+	r := send(t, module, storage.BuildRequest().SetRequestType(storage.TypeFetchEntry).SetIndex("test").SetDB(db).SetEntry(entry))
+	data, ok := (<-r.Reply).(*storage.Data)
+	if !ok {
+		return "", false
+	}
+	return data.Object.(testObject).Name, true
+}
+
+// byName returns the entries of the DB indexed under the name by the "name" secondary index.
+func byName(t *testing.T, module *ShardedModule, db, name string) []string {
+	t.Helper()
+	r := send(t, module, storage.BuildRequest().SetRequestType(storage.TypeFetchByIndex).SetIndex("test").SetDB(db).SetIndexKey("name", name))
+	reply := <-r.Reply
+	entries, ok := reply.([]*storage.ScanEntry)
+	if !ok {
+		t.Fatalf("fetch by index: got %v", reply)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Entry)
+	}
+	return names
+}
+
+// checkEntries checks that the entry "e" of each DB still holds the name of the DB.
+func checkEntries(t *testing.T, module *ShardedModule, dbs []string) {
+	t.Helper()
+	for _, db := range dbs {
+		if name, ok := get(t, module, db, "e"); !ok || name != db {
+			t.Fatalf("db %v: got %q, %v", db, name, ok)
+		}
+	}
+}
+
+// waitEvent waits for the watch to receive an event for the DB.
+func waitEvent(t *testing.T, watch *storage.Request, db string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case reply, ok := <-watch.Reply:
+			if !ok {
+				t.Fatal("watch closed")
+			}
+			if event := reply.(*storage.Event); event.DB == db {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no event for db %v", db)
+		}
+	}
+}
+
+func TestReshard(t *testing.T) {
+	module := newTestModule(t, 2)
+	defer module.Stop()
+
+	var dbs []string
+	for n := 0; n < 20; n++ {
+		db := fmt.Sprint("db", n)
+		dbs = append(dbs, db)
+		set(t, module, db, "e", db)
+	}
+	checkEntries(t, module, dbs)
+	watch := send(t, module, storage.BuildRequest().SetRequestWatch().SetIndex("test"))
+	defer watch.Cancel()
+
+	// A DB which moves on both reshards, and whose secondary index is declared while it does not exist
+	declared := ""
+	for n := 0; declared == ""; n++ {
+		db := fmt.Sprint("declared", n)
+		if shardFor("test", db, 2) != shardFor("test", db, 4) && shardFor("test", db, 4) != 0 {
+			declared = db
+		}
+	}
+	send(t, module, storage.BuildRequest().SetRequestType(storage.TypeSetSecondaryIndex).SetIndex("test").SetDB(declared).
+		SetSecondaryIndex("name", func(o storage.Object) []string { return []string{o.(testObject).Name} }))
+	send(t, module, storage.BuildRequest().SetRequestType(storage.TypeDeleteDB).SetIndex("test").SetDB(declared))
+
+	// Grow
+	if err := module.Reshard(4); err != nil {
+		t.Fatal(err)
+	}
+	if module.Shards() != 4 {
+		t.Fatalf("grow: got %d shards", module.Shards())
+	}
+	checkEntries(t, module, dbs)
+	for _, db := range dbs {
+		if shardFor("test", db, 4) >= 2 {
+			set(t, module, db, "w", db)
+			waitEvent(t, watch, db)
+			break
+		}
+	}
+	set(t, module, declared, "a", "x")
+	if names := byName(t, module, declared, "x"); fmt.Sprint(names) != "[a]" {
+		t.Fatalf("grow: by index got %v", names)
+	}
+	send(t, module, storage.BuildRequest().SetRequestType(storage.TypeDeleteDB).SetIndex("test").SetDB(declared))
+
+	// Shrink
+	if err := module.Reshard(1); err != nil {
+		t.Fatal(err)
+	}
+	if module.Shards() != 1 {
+		t.Fatalf("shrink: got %d shards", module.Shards())
+	}
+	checkEntries(t, module, dbs)
+	set(t, module, dbs[0], "w", dbs[0])
+	waitEvent(t, watch, dbs[0])
+	set(t, module, declared, "b", "y")
+	if names := byName(t, module, declared, "y"); fmt.Sprint(names) != "[b]" {
+		t.Fatalf("shrink: by index got %v", names)
+	}
+
+	// Rollback when a DB cannot be detached
+	failed := errors.New("detach failed")
+	moved := 0
+	detachDB = func(shard *inmemory.InMemoryModule, index, db string) (*inmemory.Database, error) {
+		if moved++; moved == 3 {
+			return nil, failed
+		}
+		return shard.DetachDB(index, db)
+	}
+	defer func() { detachDB = (*inmemory.InMemoryModule).DetachDB }()
+	if err := module.Reshard(3); !errors.Is(err, failed) {
+		t.Fatalf("rollback: got %v", err)
+	}
+	if module.Shards() != 1 {
+		t.Fatalf("rollback: got %d shards", module.Shards())
+	}
+	checkEntries(t, module, dbs)
+	set(t, module, dbs[1], "w", dbs[1])
+	waitEvent(t, watch, dbs[1])
+	if names := byName(t, module, declared, "y"); fmt.Sprint(names) != "[b]" {
+		t.Fatalf("rollback: by index got %v", names)
+	}
+}
@@ -39,6 +39,76 @@ const (
 	// Requires Reply, Cluster, and Topic fields.
 	// Returns a []int64
 	TypeFetchEntry RequestConstant = 5
+
+	// TypeWatch is the request type to receive change notifications for an Index, a DB or Entry key prefix.
+	// Requires Reply, Done and Index fields. DB and Entry narrow the watch, Entry is matched as a key prefix and
+	// requires DB. Sends an *Event over Reply for every change until the request is cancelled. Reply must be
+	// buffered: if it is full when an event is sent, the watch is closed and Reply is closed.
+	TypeWatch RequestConstant = 6
+
+	// TypeBatch is the request type to apply an ordered list of TypeSetEntry, TypeDeleteEntry, TypeFetchEntry and
+	// TypeFetchEntries operations with a single request. Requires Reply and Operations fields. Returns a *BatchResult
+	TypeBatch RequestConstant = 7
+
+	// TypeCompareAndSet is the request type to store an Entry only if its current version matches the Version field.
+	// A Version of 0 requires that the Entry does not exist. Requires Reply, Index, DB and Entry fields.
+	// Returns the stored *Data, or an error if the version does not match.
+	TypeCompareAndSet RequestConstant = 8
+
+	// TypeDeleteDB is the request type to remove a DB and all of its entries. Requires Index and DB fields
+	TypeDeleteDB RequestConstant = 9
+
+	// TypeDeleteIndex is the request type to remove an Index and all of its DBs. Requires the Index field
+	TypeDeleteIndex RequestConstant = 10
+
+	// TypeFetchDatabases is the request type to retrieve a list of DBs in an Index. Requires Reply and Index
+	// fields. Returns a []string
+	TypeFetchDatabases RequestConstant = 11
+
+	// TypeScan is the request type to retrieve a page of entries of a DB in sorted key order. Requires Reply, Index and
+	// DB fields, with the range, limit and cursor given in Scan. Returns a *ScanResult
+	TypeScan RequestConstant = 12
+
+	// TypeSetSecondaryIndex is the request type to declare a secondary index of a DB, maintained on every change to
+	// its entries. Requires Index and DB fields, and the index Name in Secondary. An Extract function replaces any
+	// index of the same name, while a nil Extract removes it. The declaration is kept by the Index, so it applies again
+	// when the DB is deleted and created again, and is removed along with the Index
+	TypeSetSecondaryIndex RequestConstant = 13
+
+	// TypeFetchByIndex is the request type to retrieve the entries of a DB indexed under a secondary index key.
+	// Requires Reply, Index and DB fields, and the index Name and Key in Secondary. Returns a []*ScanEntry in sorted
+	// key order
+	TypeFetchByIndex RequestConstant = 14
+
+	// TypeIncrement is the request type to atomically add the Object, an Int64 or Float64, to the counter stored in
+	// an Entry. An Entry which does not exist counts from zero. Requires Reply, Index, DB and Entry fields.
+	// Returns the stored *Data holding the new value
+	TypeIncrement RequestConstant = 15
+
+	// TypeDecrement is the same as TypeIncrement, but subtracts the Object from the counter
+	TypeDecrement RequestConstant = 16
+
+	// TypeTransaction is the request type to apply an ordered list of TypeSetEntry, TypeDeleteEntry, TypeFetchEntry
+	// and TypeFetchEntries operations on the DBs of one Index atomically. Operations may require the Entry to be at a
+	// given version using CheckVersion. If any operation fails, none of the changes are kept. Requires Reply, Index and
+	// Operations fields. Returns a *TransactionResult
+	TypeTransaction RequestConstant = 17
+
+	// TypeAppendEntry is the request type to push the Object into a fixed-size ring of timestamped values stored in an
+	// Entry. The Timestamp field is the time of the value in Unix nanoseconds, or the time the request is handled if
+	// zero. The storage module sets the size of the ring and drops values too close to the newest one. Requires
+	// Index, DB, Entry and Object fields. TypeFetchEntry returns the window as a *Ring in the Object of the *Data
+	TypeAppendEntry RequestConstant = 18
+
+	// TypeSnapshot is the request type to create a read-only, point-in-time view of an Index, or of every Index if
+	// Index is empty. TypeFetchIndexes, TypeFetchDatabases, TypeFetchEntries, TypeFetchEntry and TypeScan requests
+	// read from the view if their Snapshot field is set to its ID. The view is kept until it is released with
+	// TypeReleaseSnapshot, or until TTL has passed, or a default TTL of the storage module if TTL is not set.
+	// Requires Reply. Returns the snapshot ID as a string
+	TypeSnapshot RequestConstant = 19
+
+	// TypeReleaseSnapshot is the request type to release a view created by TypeSnapshot. Requires the Snapshot field
+	TypeReleaseSnapshot RequestConstant = 20
 )
 
 var storageRequestStrings = [...]string{
@@ -48,6 +118,21 @@ var storageRequestStrings = [...]string{
 	"TypeFetchIndexes",
 	"TypeFetchEntries",
 	"TypeFetchEntry",
+	"TypeWatch",
+	"TypeBatch",
+	"TypeCompareAndSet",
+	"TypeDeleteDB",
+	"TypeDeleteIndex",
+	"TypeFetchDatabases",
+	"TypeScan",
+	"TypeSetSecondaryIndex",
+	"TypeFetchByIndex",
+	"TypeIncrement",
+	"TypeDecrement",
+	"TypeTransaction",
+	"TypeAppendEntry",
+	"TypeSnapshot",
+	"TypeReleaseSnapshot",
 }
 
 // RequestHandler handles a storage Request.
@@ -65,12 +150,27 @@ func NoopHandler(request *Request) {
 // HandleRequestMap contains the available Storage Request options
 // which can be used to assign RequestHandlers. For convenience.
 var HandleRequestMap = map[RequestConstant]RequestHandler{
-	TypeSetIndex:     nil,
-	TypeSetEntry:     nil,
-	TypeDeleteEntry:  nil,
-	TypeFetchIndexes: nil,
-	TypeFetchEntries: nil,
-	TypeFetchEntry:   nil,
+	TypeSetIndex:          nil,
+	TypeSetEntry:          nil,
+	TypeDeleteEntry:       nil,
+	TypeFetchIndexes:      nil,
+	TypeFetchEntries:      nil,
+	TypeFetchEntry:        nil,
+	TypeWatch:             nil,
+	TypeBatch:             nil,
+	TypeCompareAndSet:     nil,
+	TypeDeleteDB:          nil,
+	TypeDeleteIndex:       nil,
+	TypeFetchDatabases:    nil,
+	TypeScan:              nil,
+	TypeSetSecondaryIndex: nil,
+	TypeFetchByIndex:      nil,
+	TypeIncrement:         nil,
+	TypeDecrement:         nil,
+	TypeTransaction:       nil,
+	TypeAppendEntry:       nil,
+	TypeSnapshot:          nil,
+	TypeReleaseSnapshot:   nil,
 }
 
 // String returns a string representation of a RequestConstant for logging
@@ -0,0 +1,109 @@
+package storage
+
+// OperationResult holds the result of a single operation of a TypeBatch Request.
+type OperationResult struct {
+	RequestType RequestConstant
+	Index       string
+	DB          string
+	Entry       string
+
+	// Data is set for a successful TypeFetchEntry operation
+	Data *Data
+
+	// Entries is set for a successful TypeFetchEntries operation
+	Entries []string
+
+	// Err is set if the operation failed
+	Err error
+}
+
+// BatchResult is sent over the Reply channel of a TypeBatch Request.
+// Results holds one OperationResult for each operation, in the order the operations were given.
+type BatchResult struct {
+	Results []*OperationResult
+}
+
+// Failed returns the results of all failed operations.
+func (b *BatchResult) Failed() []*OperationResult {
+	var failed []*OperationResult
+	for _, result := range b.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// BatchSplit is a TypeBatch Request split into a TypeBatch Request for each target, such as a storage module or a
+// shard. Requests[i] holds the operations for Targets[i], in order, and has its own Reply channel.
+type BatchSplit[K comparable] struct {
+	Targets  []K
+	Requests []*Request
+
+	request   *Request
+	positions [][]int
+}
+
+// SplitBatch splits the operations of a TypeBatch Request by the target of each operation.
+func SplitBatch[K comparable](request *Request, target func(op *Request) K) *BatchSplit[K] {
+	split := &BatchSplit[K]{
+		request: request,
+	}
+	targets := make(map[K]int)
+	for i, op := range request.Operations {
+		key := target(op)
+		n, ok := targets[key]
+		if !ok {
+			n = len(split.Targets)
+			targets[key] = n
+			forward := *request
+			forward.Operations = nil
+			forward.Reply = make(chan interface{}, 1)
+			split.Targets = append(split.Targets, key)
+			split.Requests = append(split.Requests, &forward)
+			split.positions = append(split.positions, nil)
+		}
+		split.Requests[n].Operations = append(split.Requests[n].Operations, op)
+		split.positions[n] = append(split.positions[n], i)
+	}
+	return split
+}
+
+// Merge waits for the reply to every split Request, then responds to the TypeBatch Request with a BatchResult holding
+// the results in the order of its operations and closes its Reply channel. Operations left without a result, because
+// a target replied with an error or did not reply, fail with that error or ErrInternal. The result is dropped if it is
+// not received within RejectTimeout. Merge must only be called once every split Request has been sent.
+func (split *BatchSplit[K]) Merge() {
+	defer close(split.request.Reply)
+	result := &BatchResult{
+		Results: make([]*OperationResult, len(split.request.Operations)),
+	}
+	for n, forward := range split.Requests {
+		var err error = Errorf(CodeInternal, "no reply for %v", split.request.RequestType)
+		for reply := range forward.Reply {
+			switch reply := reply.(type) {
+			case *BatchResult:
+				for j, opResult := range reply.Results {
+					if j < len(split.positions[n]) {
+						result.Results[split.positions[n][j]] = opResult
+					}
+				}
+			case error:
+				err = reply
+			}
+		}
+		for _, i := range split.positions[n] {
+			if result.Results[i] == nil {
+				op := split.request.Operations[i]
+				result.Results[i] = &OperationResult{
+					RequestType: op.RequestType,
+					Index:       op.Index,
+					DB:          op.DB,
+					Entry:       op.Entry,
+					Err:         err,
+				}
+			}
+		}
+	}
+	split.request.RespondWithin(result, RejectTimeout)
+}
@@ -0,0 +1,113 @@
+package storage
+
+import "context"
+
+// Sender sends a Request and waits for its Response. It is implemented by modules.Mod, and by ChannelSender for a
+// raw storage channel.
+type Sender interface {
+	SendStorageRequestContext(ctx context.Context, sr *Request) *Response
+}
+
+// ChannelSender is a Sender for a raw storage channel.
+type ChannelSender chan *Request
+
+// SendStorageRequestContext sends a Request to the channel and waits for any reply.
+func (ch ChannelSender) SendStorageRequestContext(ctx context.Context, sr *Request) *Response {
+	return SendRequestContext(ctx, ch, sr)
+}
+
+// Client is a typed storage client for Objects of type T. It builds the Requests, waits for the replies and
+// converts them, so callers never handle Reply channels or type assertions.
+type Client[T Object] struct {
+	sender Sender
+}
+
+// NewClient returns a Client which sends Requests using the given Sender, such as a modules.Mod.
+func NewClient[T Object](sender Sender) *Client[T] {
+	return &Client[T]{
+		sender: sender,
+	}
+}
+
+// NewChannelClient returns a Client which sends Requests over a raw storage channel.
+func NewChannelClient[T Object](storageChannel chan *Request) *Client[T] {
+	return NewClient[T](ChannelSender(storageChannel))
+}
+
+// Get returns the Object stored in the Entry. An Object which is not of type T is reported with ErrInvalidType, and
+// a Reply closed without a value, such as for a request skipped once its context is done, with ErrTimeout.
+func (c *Client[T]) Get(ctx context.Context, index, db, entry string) (T, error) {
+	var obj T
+	sr, err := BuildRequest().SetRequestType(TypeFetchEntry).SetIndex(index).SetDB(db).SetEntry(entry).Build()
+	if err != nil {
+		return obj, err
+	}
+	response := c.sender.SendStorageRequestContext(ctx, sr)
+	switch {
+	case response.Err != nil:
+		return obj, response.Err
+	case response.Reply == nil:
+		return obj, Errorf(CodeTimeout, "no reply for %v", TypeFetchEntry)
+	case !response.HasObject:
+		return obj, Errorf(CodeUnknownEntry, "%v", entry)
+	}
+	obj, ok := response.Object.(T)
+	if !ok {
+		return obj, Errorf(CodeInvalidType, "%v: %T is not a %T", entry, response.Object, obj)
+	}
+	return obj, nil
+}
+
+// Put stores the Object in the Entry. Storage modules do not reply to TypeSetEntry requests, so only invalid
+// requests and timeouts sending the request are reported. Once sent, the Entry is stored even if the context is done.
+func (c *Client[T]) Put(ctx context.Context, index, db, entry string, obj T) error {
+	sr, err := BuildRequest().SetRequestType(TypeSetEntry).SetIndex(index).SetDB(db).SetEntry(entry).SetObject(obj).Build()
+	if err != nil {
+		return err
+	}
+	return c.sender.SendStorageRequestContext(ctx, sr).Err
+}
+
+// Delete removes the Entry. As with Put, only invalid requests and timeouts sending the request are reported, and once
+// sent, the Entry is removed even if the context is done.
+func (c *Client[T]) Delete(ctx context.Context, index, db, entry string) error {
+	sr, err := BuildRequest().SetRequestType(TypeDeleteEntry).SetIndex(index).SetDB(db).SetEntry(entry).Build()
+	if err != nil {
+		return err
+	}
+	return c.sender.SendStorageRequestContext(ctx, sr).Err
+}
+
+// List returns the names of all entries in the DB.
+func (c *Client[T]) List(ctx context.Context, index, db string) ([]string, error) {
+	return c.list(ctx, BuildRequest().SetRequestType(TypeFetchEntries).SetIndex(index).SetDB(db))
+}
+
+// Databases returns the names of all DBs in the Index.
+func (c *Client[T]) Databases(ctx context.Context, index string) ([]string, error) {
+	return c.list(ctx, BuildRequest().SetRequestType(TypeFetchDatabases).SetIndex(index))
+}
+
+// Indexes returns the names of all Indexes.
+func (c *Client[T]) Indexes(ctx context.Context) ([]string, error) {
+	return c.list(ctx, BuildRequest().SetRequestType(TypeFetchIndexes))
+}
+
+func (c *Client[T]) list(ctx context.Context, builder *RequestBuilder) ([]string, error) {
+	sr, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	response := c.sender.SendStorageRequestContext(ctx, sr)
+	switch {
+	case response.Err != nil:
+		return nil, response.Err
+	case response.Reply == nil:
+		return nil, Errorf(CodeTimeout, "no reply for %v", builder.RequestType)
+	}
+	list, ok := response.Reply.([]string)
+	if !ok {
+		return nil, Errorf(CodeInvalidType, "%v: %T is not a []string", builder.RequestType, response.Reply)
+	}
+	return list, nil
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// Codec converts Objects of a single type to and from bytes.
+type Codec struct {
+	Encode func(Object) ([]byte, error)
+	Decode func([]byte) (Object, error)
+}
+
+// JSONCodec returns a Codec which uses encoding/json for Objects of the same type as prototype.
+func JSONCodec(prototype Object) Codec {
+	return Codec{
+		Encode: func(obj Object) ([]byte, error) {
+			return json.Marshal(obj)
+		},
+		Decode: func(b []byte) (Object, error) {
+			return decodeInto(prototype, func(v interface{}) error {
+				return json.Unmarshal(b, v)
+			})
+		},
+	}
+}
+
+// GobCodec returns a Codec which uses encoding/gob for Objects of the same type as prototype.
+func GobCodec(prototype Object) Codec {
+	return Codec{
+		Encode: func(obj Object) ([]byte, error) {
+			var buf bytes.Buffer
+			err := gob.NewEncoder(&buf).Encode(obj)
+			return buf.Bytes(), err
+		},
+		Decode: func(b []byte) (Object, error) {
+			return decodeInto(prototype, func(v interface{}) error {
+				return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+			})
+		},
+	}
+}
+
+// encodedRing is the serialized form of a Ring, with the Object of each point encoded by its registered Codec.
+type encodedRing struct {
+	Size   int                `json:"size"`
+	Points []encodedRingPoint `json:"points,omitempty"`
+}
+
+type encodedRingPoint struct {
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type,omitempty"`
+	Object    []byte `json:"object,omitempty"`
+}
+
+// ringCodec is the Codec of *Ring, which encodes the Object of each point using EncodeObject, so a Ring can be
+// encoded whenever the Objects appended to it can.
+var ringCodec = Codec{
+	Encode: func(obj Object) ([]byte, error) {
+		r := obj.(*Ring)
+		e := encodedRing{
+			Size:   r.Size,
+			Points: make([]encodedRingPoint, len(r.Points)),
+		}
+		for i, point := range r.Points {
+			e.Points[i].Timestamp = point.Timestamp
+			if point.Object == nil {
+				continue
+			}
+			var err error
+			e.Points[i].Type, e.Points[i].Object, err = EncodeObject(point.Object)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(&e)
+	},
+	Decode: func(b []byte) (Object, error) {
+		var e encodedRing
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, err
+		}
+		r := &Ring{
+			Size:   e.Size,
+			Points: make([]RingPoint, len(e.Points)),
+		}
+		for i, point := range e.Points {
+			r.Points[i].Timestamp = point.Timestamp
+			if point.Type == "" {
+				continue
+			}
+			var err error
+			r.Points[i].Object, err = DecodeObject(point.Type, point.Object)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	},
+}
+
+// decodeInto decodes into a new value of the type of prototype, which may be a pointer type.
+func decodeInto(prototype Object, decode func(interface{}) error) (Object, error) {
+	t := reflect.TypeOf(prototype)
+	isPtr := t.Kind() == reflect.Ptr
+	if isPtr {
+		t = t.Elem()
+	}
+	v := reflect.New(t)
+	if err := decode(v.Interface()); err != nil {
+		return nil, err
+	}
+	if isPtr {
+		return v.Interface().(Object), nil
+	}
+	return v.Elem().Interface().(Object), nil
+}
+
+// codecs is the registry of Codecs by type name, and of the type names by Object type and the reverse.
+var codecs = struct {
+	sync.RWMutex
+	byName map[string]Codec
+	names  map[reflect.Type]string
+	types  map[string]reflect.Type
+}{
+	byName: make(map[string]Codec),
+	names:  make(map[reflect.Type]string),
+	types:  make(map[string]reflect.Type),
+}
+
+// RegisterCodec registers the Codec for Objects of the same type as prototype under the given type name. The name is
+// stored alongside encoded Objects, so it must stay the same for Objects to be decoded by other processes.
+// Registering a name or type again replaces the previous registration of both, so a type registered under a new name
+// can no longer be decoded under its previous name.
+func RegisterCodec(name string, prototype Object, codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	t := reflect.TypeOf(prototype)
+	if previous, ok := codecs.names[t]; ok && previous != name {
+		delete(codecs.byName, previous)
+		delete(codecs.types, previous)
+	}
+	if previous, ok := codecs.types[name]; ok && previous != t {
+		delete(codecs.names, previous)
+	}
+	codecs.byName[name] = codec
+	codecs.names[t] = name
+	codecs.types[name] = t
+}
+
+// HasCodec returns true if a Codec is registered for Objects of the same type as obj.
+func HasCodec(obj Object) bool {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	_, ok := codecs.names[reflect.TypeOf(obj)]
+	return ok
+}
+
+// RegisterJSON registers Objects of the same type as prototype to be encoded with encoding/json.
+func RegisterJSON(name string, prototype Object) {
+	RegisterCodec(name, prototype, JSONCodec(prototype))
+}
+
+// RegisterGob registers Objects of the same type as prototype to be encoded with encoding/gob.
+func RegisterGob(name string, prototype Object) {
+	RegisterCodec(name, prototype, GobCodec(prototype))
+}
+
+func init() {
+	RegisterJSON("storage.Int64", Int64(0))
+	RegisterJSON("storage.Float64", Float64(0))
+	RegisterCodec("storage.Ring", &Ring{}, ringCodec)
+}
+
+// EncodeObject encodes the Object using its registered Codec and returns the encoded bytes with the type name.
+func EncodeObject(obj Object) (string, []byte, error) {
+	codecs.RLock()
+	name, ok := codecs.names[reflect.TypeOf(obj)]
+	codec := codecs.byName[name]
+	codecs.RUnlock()
+	if !ok {
+		return "", nil, Errorf(CodeInvalidType, "no codec registered for %T", obj)
+	}
+	b, err := codec.Encode(obj)
+	return name, b, err
+}
+
+// DecodeObject decodes an Object encoded by EncodeObject using the Codec registered under the type name.
+func DecodeObject(name string, b []byte) (Object, error) {
+	codecs.RLock()
+	codec, ok := codecs.byName[name]
+	codecs.RUnlock()
+	if !ok {
+		return nil, Errorf(CodeInvalidType, "no codec registered for type %v", name)
+	}
+	return codec.Decode(b)
+}
+
+// EncodedData is the serialized form of Data, with the Object encoded by its registered Codec and tagged with its
+// type name so any backend can decode it.
+type EncodedData struct {
+	Type     string `json:"type,omitempty"`
+	Object   []byte `json:"object,omitempty"`
+	Version  uint64 `json:"version,omitempty"`
+	Modified int64  `json:"modified,omitempty"`
+	Expires  int64  `json:"expires,omitempty"`
+}
+
+// Encode returns the EncodedData for the Data. A nil Object is encoded without a type.
+func (d *Data) Encode() (*EncodedData, error) {
+	e := &EncodedData{
+		Version:  d.Version,
+		Modified: d.Modified,
+		Expires:  d.Expires,
+	}
+	if d.Object == nil {
+		return e, nil
+	}
+	var err error
+	e.Type, e.Object, err = EncodeObject(d.Object)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Decode returns the Data for the EncodedData.
+func (e *EncodedData) Decode() (*Data, error) {
+	d := &Data{
+		Version:  e.Version,
+		Modified: e.Modified,
+		Expires:  e.Expires,
+	}
+	if e.Type == "" {
+		return d, nil
+	}
+	var err error
+	d.Object, err = DecodeObject(e.Type, e.Object)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Marshal returns the Data as JSON, with the Object encoded by its registered Codec and tagged with its type name.
+func (d *Data) Marshal() ([]byte, error) {
+	e, err := d.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// UnmarshalData returns the Data encoded by Data.Marshal.
+func UnmarshalData(b []byte) (*Data, error) {
+	var e EncodedData
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	return e.Decode()
+}
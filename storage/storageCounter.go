@@ -0,0 +1,62 @@
+package storage
+
+import "strconv"
+
+// Int64 is an Object holding an integer counter, used by TypeIncrement and TypeDecrement requests.
+type Int64 int64
+
+// ID returns the value as a string.
+func (i Int64) ID() string {
+	return strconv.FormatInt(int64(i), 10)
+}
+
+// Float64 is an Object holding a floating point counter, used by TypeIncrement and TypeDecrement requests.
+type Float64 float64
+
+// ID returns the value as a string.
+func (f Float64) ID() string {
+	return strconv.FormatFloat(float64(f), 'g', -1, 64)
+}
+
+// isCounter returns true if the Object can be used as the delta of a TypeIncrement or TypeDecrement request.
+func isCounter(obj Object) bool {
+	switch obj.(type) {
+	case Int64, Float64:
+		return true
+	}
+	return false
+}
+
+// ApplyDelta returns the value of a counter after applying the delta of a TypeIncrement or TypeDecrement Request.
+// A nil current value counts from zero. The current value must be of the same type as the delta.
+func ApplyDelta(current Object, request *Request) (Object, error) {
+	switch delta := request.Object.(type) {
+	case Int64:
+		var value Int64
+		if current != nil {
+			v, ok := current.(Int64)
+			if !ok {
+				return nil, Errorf(CodeInvalidType, "%v: %T is not an Int64", request.Entry, current)
+			}
+			value = v
+		}
+		if request.RequestType == TypeDecrement {
+			return value - delta, nil
+		}
+		return value + delta, nil
+	case Float64:
+		var value Float64
+		if current != nil {
+			v, ok := current.(Float64)
+			if !ok {
+				return nil, Errorf(CodeInvalidType, "%v: %T is not a Float64", request.Entry, current)
+			}
+			value = v
+		}
+		if request.RequestType == TypeDecrement {
+			return value - delta, nil
+		}
+		return value + delta, nil
+	}
+	return nil, Errorf(CodeInvalidType, "%v: delta %T is not an Int64 or Float64", request.Entry, request.Object)
+}
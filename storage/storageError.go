@@ -0,0 +1,98 @@
+package storage
+
+import "fmt"
+
+// ErrCode is a numerical code for a storage Error.
+type ErrCode int
+
+// ErrCode Constants
+const (
+	CodeUnknownIndex          ErrCode = 0
+	CodeUnknownDB             ErrCode = 1
+	CodeUnknownEntry          ErrCode = 2
+	CodeVersionConflict       ErrCode = 3
+	CodeInvalidRequest        ErrCode = 4
+	CodeTimeout               ErrCode = 5
+	CodeUnknownSecondaryIndex ErrCode = 6
+	CodeInvalidType           ErrCode = 7
+	CodeForbidden             ErrCode = 8
+	CodeInternal              ErrCode = 9
+	CodeUnknownSnapshot       ErrCode = 10
+	CodeReadOnly              ErrCode = 11
+	CodeStopped               ErrCode = 12
+)
+
+// ErrCodeMap contains a map of codes to error string.
+var ErrCodeMap = map[ErrCode]string{
+	CodeUnknownIndex:          "unknown index",
+	CodeUnknownDB:             "unknown db",
+	CodeUnknownEntry:          "unknown entry",
+	CodeVersionConflict:       "version conflict",
+	CodeInvalidRequest:        "invalid request",
+	CodeTimeout:               "timeout",
+	CodeUnknownSecondaryIndex: "unknown secondary index",
+	CodeInvalidType:           "invalid type",
+	CodeForbidden:             "forbidden",
+	CodeInternal:              "internal error",
+	CodeUnknownSnapshot:       "unknown snapshot",
+	CodeReadOnly:              "read only",
+	CodeStopped:               "stopped",
+}
+
+// Sentinel errors for each ErrCode. Any error returned in a Response can be tested against these using errors.Is.
+var (
+	ErrUnknownIndex          = &Error{Code: CodeUnknownIndex}
+	ErrUnknownDB             = &Error{Code: CodeUnknownDB}
+	ErrUnknownEntry          = &Error{Code: CodeUnknownEntry}
+	ErrVersionConflict       = &Error{Code: CodeVersionConflict}
+	ErrInvalidRequest        = &Error{Code: CodeInvalidRequest}
+	ErrTimeout               = &Error{Code: CodeTimeout}
+	ErrUnknownSecondaryIndex = &Error{Code: CodeUnknownSecondaryIndex}
+	ErrInvalidType           = &Error{Code: CodeInvalidType}
+	ErrForbidden             = &Error{Code: CodeForbidden}
+	ErrInternal              = &Error{Code: CodeInternal}
+	ErrUnknownSnapshot       = &Error{Code: CodeUnknownSnapshot}
+	ErrReadOnly              = &Error{Code: CodeReadOnly}
+	ErrStopped               = &Error{Code: CodeStopped}
+)
+
+// Error is a storage error identified by its ErrCode.
+// Storage modules either reply with an Error or with an error which unwraps to one.
+type Error struct {
+	Code    ErrCode
+	Message string
+
+	// Err is the underlying cause, if any.
+	Err error
+}
+
+// Error returns the error string.
+func (e *Error) Error() string {
+	msg := ErrCodeMap[e.Code]
+	if e.Message != "" {
+		msg += `: ` + e.Message
+	}
+	if e.Err != nil {
+		msg += `: ` + e.Err.Error()
+	}
+	return msg
+}
+
+// Is returns true if the target is an *Error with the same ErrCode.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Unwrap returns the underlying cause, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errorf constructs a storage Error with the given ErrCode.
+func Errorf(code ErrCode, format string, v ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, v...),
+	}
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EventConstant is used in Event to indicate the type of change.
+type EventConstant int
+
+const (
+	// EventSet is sent when an Entry is added or replaced. Old is nil if the Entry did not exist.
+	EventSet EventConstant = 0
+
+	// EventDelete is sent when an Entry is deleted. New is always nil.
+	EventDelete EventConstant = 1
+
+	// EventExpire is sent when an expired Entry is removed by the storage module. New is always nil.
+	EventExpire EventConstant = 2
+
+	// EventDeleteDB is sent when a DB is removed. Entry, Old and New are always empty.
+	EventDeleteDB EventConstant = 3
+
+	// EventDeleteIndex is sent when an Index is removed. DB, Entry, Old and New are always empty.
+	EventDeleteIndex EventConstant = 4
+
+	// EventSetIndex is recorded when an Index is created. DB, Entry, Old and New are always empty. It is only recorded
+	// in the journal of a storage module, and is not sent to watches.
+	EventSetIndex EventConstant = 5
+
+	// EventEvict is sent when an Entry is evicted by the memory limits of the storage module, if the module is
+	// configured to send eviction events. New is always nil.
+	EventEvict EventConstant = 6
+)
+
+var storageEventStrings = [...]string{
+	"EventSet",
+	"EventDelete",
+	"EventExpire",
+	"EventDeleteDB",
+	"EventDeleteIndex",
+	"EventSetIndex",
+	"EventEvict",
+}
+
+// WatchBufferSize is the size of the Reply channel created for a TypeWatch request by RequestBuilder.
+var WatchBufferSize = 64
+
+// Event is sent over the Reply channel of a TypeWatch request for every change matching the watch.
+type Event struct {
+	Type  EventConstant
+	Index string
+	DB    string
+	Entry string
+	Old   *Data
+	New   *Data
+}
+
+// Matches returns true if the Event falls under the Index, DB and Entry key prefix of the given TypeWatch Request.
+// The removal of an Index or DB matches every watch under it, regardless of the Entry key prefix.
+func (e *Event) Matches(watch *Request) bool {
+	switch {
+	case e.Index != watch.Index:
+		return false
+	case e.Type == EventDeleteIndex:
+		return true
+	case watch.DB != "" && e.DB != watch.DB:
+		return false
+	case e.Type == EventDeleteDB:
+		return true
+	default:
+		return strings.HasPrefix(e.Entry, watch.Entry)
+	}
+}
+
+// String returns a string representation of an EventConstant for logging
+func (c EventConstant) String() string {
+	if (c >= 0) && (c < EventConstant(len(storageEventStrings))) {
+		return storageEventStrings[c]
+	}
+	return "UNKNOWN"
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. The status is the string representation of
+// EventConstant
+func (c EventConstant) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// MarshalJSON implements the json.Marshaler interface. The status is the string representation of
+// EventConstant
+func (c EventConstant) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface, parsing the string representation of an
+// EventConstant
+func (c *EventConstant) UnmarshalText(text []byte) error {
+	for i, s := range storageEventStrings {
+		if s == string(text) {
+			*c = EventConstant(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event type %q", text)
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+)
+
+// EvictionPolicy selects the entry evicted when a storage module reaches its memory limits.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently set or fetched entry.
+	EvictLRU EvictionPolicy = 0
+
+	// EvictLFU evicts the least frequently set or fetched entry, and the least recently used of those.
+	EvictLFU EvictionPolicy = 1
+
+	// EvictFIFO evicts the entry which was added first. Replacing an entry does not change its position.
+	EvictFIFO EvictionPolicy = 2
+)
+
+var evictionPolicyStrings = [...]string{
+	"lru",
+	"lfu",
+	"fifo",
+}
+
+// String returns a string representation of an EvictionPolicy for logging
+func (p EvictionPolicy) String() string {
+	if (p >= 0) && (p < EvictionPolicy(len(evictionPolicyStrings))) {
+		return evictionPolicyStrings[p]
+	}
+	return "UNKNOWN"
+}
+
+// ParseEvictionPolicy returns the EvictionPolicy for the given name, either lru, lfu or fifo. An empty name is lru.
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	if name == "" {
+		return EvictLRU, nil
+	}
+	for i, s := range evictionPolicyStrings {
+		if strings.EqualFold(s, name) {
+			return EvictionPolicy(i), nil
+		}
+	}
+	return EvictLRU, fmt.Errorf("unknown eviction policy %q", name)
+}
+
+// Limits are the memory limits of a storage module. A zero limit is unlimited.
+type Limits struct {
+	// MaxEntries is the most entries kept across all Databases.
+	MaxEntries int
+
+	// MaxDBEntries is the most entries kept in each Database.
+	MaxDBEntries int
+
+	// MaxBytes is the approximate most bytes kept across all Databases, as estimated by EntrySize.
+	MaxBytes int64
+
+	// Policy selects the entries evicted once a limit is reached.
+	Policy EvictionPolicy
+}
+
+// Enabled returns true if any limit is set.
+func (l Limits) Enabled() bool {
+	return l.MaxEntries > 0 || l.MaxDBEntries > 0 || l.MaxBytes > 0
+}
+
+// Global returns true if a limit across all Databases is set.
+func (l Limits) Global() bool {
+	return l.MaxEntries > 0 || l.MaxBytes > 0
+}
+
+// Over returns true if the given number of entries and bytes across all Databases are over the limits.
+func (l Limits) Over(entries int, bytes int64) bool {
+	return (l.MaxEntries > 0 && entries > l.MaxEntries) || (l.MaxBytes > 0 && bytes > l.MaxBytes)
+}
+
+// Sizer is implemented by Objects which can estimate their size in memory, in bytes.
+type Sizer interface {
+	ApproxSize() int64
+}
+
+// DefaultObjectSize is the size in bytes assumed for Objects which do not implement Sizer.
+var DefaultObjectSize int64 = 64
+
+// ObjectSize returns the approximate size of the Object in bytes, using Sizer if it is implemented.
+func ObjectSize(obj interface{}) int64 {
+	switch obj := obj.(type) {
+	case nil:
+		return 0
+	case Sizer:
+		return obj.ApproxSize()
+	case string:
+		return int64(len(obj))
+	case []byte:
+		return int64(len(obj))
+	}
+	return DefaultObjectSize
+}
+
+// EntrySize returns the approximate size of the Entry in bytes, counting both its key and its Object.
+func EntrySize(entry string, obj interface{}) int64 {
+	return int64(len(entry)) + ObjectSize(obj)
+}
+
+// ApproxSize returns the size of the counter.
+func (i Int64) ApproxSize() int64 {
+	return 8
+}
+
+// ApproxSize returns the size of the counter.
+func (f Float64) ApproxSize() int64 {
+	return 8
+}
+
+// ApproxSize returns the sum of the sizes of the points in the Ring.
+func (r *Ring) ApproxSize() int64 {
+	var size int64
+	for _, point := range r.Points {
+		size += 8 + ObjectSize(point.Object)
+	}
+	return size
+}
+
+// Evictor orders keys for eviction by an EvictionPolicy and keeps the count and total size of the keys. It is not
+// safe for concurrent use.
+type Evictor[K comparable] struct {
+	items map[K]*evictItem[K]
+	heap  evictHeap[K]
+	clock uint64
+	bytes int64
+}
+
+type evictItem[K comparable] struct {
+	key      K
+	size     int64
+	added    uint64
+	accessed uint64
+	count    uint64
+	pos      int
+}
+
+// NewEvictor returns an empty Evictor for the EvictionPolicy.
+func NewEvictor[K comparable](policy EvictionPolicy) *Evictor[K] {
+	return &Evictor[K]{
+		items: make(map[K]*evictItem[K]),
+		heap:  evictHeap[K]{policy: policy},
+	}
+}
+
+// Add adds the key with its size, or records an access of the key and updates its size if it already exists.
+func (e *Evictor[K]) Add(key K, size int64) {
+	e.clock++
+	if item, ok := e.items[key]; ok {
+		e.bytes += size - item.size
+		item.size = size
+		item.accessed = e.clock
+		item.count++
+		heap.Fix(&e.heap, item.pos)
+		return
+	}
+	item := &evictItem[K]{
+		key:      key,
+		size:     size,
+		added:    e.clock,
+		accessed: e.clock,
+		count:    1,
+	}
+	e.items[key] = item
+	e.bytes += size
+	heap.Push(&e.heap, item)
+}
+
+// Touch records an access of the key, if it exists.
+func (e *Evictor[K]) Touch(key K) {
+	item, ok := e.items[key]
+	if !ok {
+		return
+	}
+	e.clock++
+	item.accessed = e.clock
+	item.count++
+	heap.Fix(&e.heap, item.pos)
+}
+
+// Remove removes the key, if it exists.
+func (e *Evictor[K]) Remove(key K) {
+	item, ok := e.items[key]
+	if !ok {
+		return
+	}
+	delete(e.items, key)
+	e.bytes -= item.size
+	heap.Remove(&e.heap, item.pos)
+}
+
+// Victim returns the key to evict next, other than skip, and true, or false if there is none.
+func (e *Evictor[K]) Victim(skip K) (K, bool) {
+	items := e.heap.items
+	if len(items) > 0 && items[0].key != skip {
+		return items[0].key, true
+	}
+	// The first key is skipped, so the next is one of its children in the heap
+	var victim *evictItem[K]
+	for i := 1; i < len(items) && i <= 2; i++ {
+		if victim == nil || e.heap.less(items[i], victim) {
+			victim = items[i]
+		}
+	}
+	if victim == nil {
+		var none K
+		return none, false
+	}
+	return victim.key, true
+}
+
+// Len returns the number of keys.
+func (e *Evictor[K]) Len() int {
+	return len(e.items)
+}
+
+// Bytes returns the total size of the keys.
+func (e *Evictor[K]) Bytes() int64 {
+	return e.bytes
+}
+
+// evictHeap implements heap.Interface, with the next key to evict first.
+type evictHeap[K comparable] struct {
+	policy EvictionPolicy
+	items  []*evictItem[K]
+}
+
+func (h *evictHeap[K]) less(a, b *evictItem[K]) bool {
+	switch h.policy {
+	case EvictLFU:
+		if a.count != b.count {
+			return a.count < b.count
+		}
+		return a.accessed < b.accessed
+	case EvictFIFO:
+		return a.added < b.added
+	}
+	return a.accessed < b.accessed
+}
+
+func (h *evictHeap[K]) Len() int           { return len(h.items) }
+func (h *evictHeap[K]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+
+func (h *evictHeap[K]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].pos = i
+	h.items[j].pos = j
+}
+
+func (h *evictHeap[K]) Push(x interface{}) {
+	item := x.(*evictItem[K])
+	item.pos = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *evictHeap[K]) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items[len(h.items)-1] = nil
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
@@ -0,0 +1,164 @@
+package storage
+
+import "testing"
+
+// evictStep is a call to an Evictor: Add with a size, Touch if touch is set, or Remove if remove is set.
+type evictStep struct {
+	key    string
+	size   int64
+	touch  bool
+	remove bool
+}
+
+func TestEvictor(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		steps  []evictStep
+		skip   string
+		victim string
+		ok     bool
+		len    int
+		bytes  int64
+	}{
+		{
+			name:   "empty",
+			policy: EvictLRU,
+		},
+		{
+			name:   "lru evicts least recently used",
+			policy: EvictLRU,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 2}, {key: "c", size: 3}, {key: "a", touch: true}},
+			victim: "b",
+			ok:     true,
+			len:    3,
+			bytes:  6,
+		},
+		{
+			name:   "lru counts a replaced key as used",
+			policy: EvictLRU,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 2}, {key: "a", size: 5}},
+			victim: "b",
+			ok:     true,
+			len:    2,
+			bytes:  7,
+		},
+		{
+			name:   "lru skips the key just set",
+			policy: EvictLRU,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 1}, {key: "c", size: 1}},
+			skip:   "a",
+			victim: "b",
+			ok:     true,
+			len:    3,
+			bytes:  3,
+		},
+		{
+			name:   "lfu evicts least frequently used",
+			policy: EvictLFU,
+			steps: []evictStep{{key: "a", size: 1}, {key: "b", size: 1}, {key: "c", size: 1},
+				{key: "a", touch: true}, {key: "c", touch: true}, {key: "c", touch: true}},
+			victim: "b",
+			ok:     true,
+			len:    3,
+			bytes:  3,
+		},
+		{
+			name:   "lfu evicts least recently used of equal counts",
+			policy: EvictLFU,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 1}, {key: "a", touch: true}, {key: "b", touch: true}},
+			victim: "a",
+			ok:     true,
+			len:    2,
+			bytes:  2,
+		},
+		{
+			name:   "lfu skips the key just set",
+			policy: EvictLFU,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 1}, {key: "c", size: 1}, {key: "c", touch: true}},
+			skip:   "a",
+			victim: "b",
+			ok:     true,
+			len:    3,
+			bytes:  3,
+		},
+		{
+			name:   "fifo evicts first added",
+			policy: EvictFIFO,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 1}, {key: "a", touch: true}, {key: "a", size: 1}},
+			victim: "a",
+			ok:     true,
+			len:    2,
+			bytes:  2,
+		},
+		{
+			name:   "fifo skips the key just set",
+			policy: EvictFIFO,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 1}, {key: "c", size: 1}},
+			skip:   "a",
+			victim: "b",
+			ok:     true,
+			len:    3,
+			bytes:  3,
+		},
+		{
+			name:   "skipping the only key",
+			policy: EvictFIFO,
+			steps:  []evictStep{{key: "a", size: 4}},
+			skip:   "a",
+			len:    1,
+			bytes:  4,
+		},
+		{
+			name:   "removed keys are not evicted",
+			policy: EvictLRU,
+			steps:  []evictStep{{key: "a", size: 1}, {key: "b", size: 2}, {key: "a", remove: true}, {key: "x", remove: true}},
+			victim: "b",
+			ok:     true,
+			len:    1,
+			bytes:  2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := NewEvictor[string](test.policy)
+			for _, step := range test.steps {
+				switch {
+				case step.touch:
+					e.Touch(step.key)
+				case step.remove:
+					e.Remove(step.key)
+				default:
+					e.Add(step.key, step.size)
+				}
+			}
+			victim, ok := e.Victim(test.skip)
+			if victim != test.victim || ok != test.ok {
+				t.Errorf("Victim(%q) = %q, %v, want %q, %v", test.skip, victim, ok, test.victim, test.ok)
+			}
+			if e.Len() != test.len || e.Bytes() != test.bytes {
+				t.Errorf("Len, Bytes = %d, %d, want %d, %d", e.Len(), e.Bytes(), test.len, test.bytes)
+			}
+		})
+	}
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		err    bool
+	}{
+		{name: "", policy: EvictLRU},
+		{name: "lru", policy: EvictLRU},
+		{name: "LFU", policy: EvictLFU},
+		{name: "fifo", policy: EvictFIFO},
+		{name: "random", policy: EvictLRU, err: true},
+	}
+	for _, test := range tests {
+		policy, err := ParseEvictionPolicy(test.name)
+		if policy != test.policy || (err != nil) != test.err {
+			t.Errorf("ParseEvictionPolicy(%q) = %v, %v", test.name, policy, err)
+		}
+	}
+}
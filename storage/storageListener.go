@@ -2,6 +2,8 @@ package storage
 
 import (
 	"sync"
+
+	"github.com/OneOfOne/xxhash"
 )
 
 // StorageListener listens on a storage Request channel and assigns an appropriate RequstHandler.
@@ -12,9 +14,21 @@ type StorageListener interface {
 // Listener implements StorageListener.
 type Listener struct {
 	RequestHandlers map[RequestConstant]RequestHandler
-	requestChannel  chan *Request
-	quitChannel     chan struct{}
-	wg              sync.WaitGroup
+
+	// Middleware wraps every RequestHandler, including the NoopHandler used for unhandled requests, in order.
+	// It must be set before Listen is called.
+	Middleware []Middleware
+
+	// Workers is the number of goroutines servicing Requests, and QueueDepth the size of each of their queues.
+	// Requests are hashed to a worker by Index and DB, so Requests for the same DB are handled in order. Requests
+	// which are not for a single DB are handled once every worker has handled the Requests queued before them, and
+	// the workers wait meanwhile. Both default to 1 and must be set before Listen is called.
+	Workers    int
+	QueueDepth int
+
+	requestChannel chan *Request
+	quitChannel    chan struct{}
+	wg             sync.WaitGroup
 }
 
 // GetCommunicationChannel returns the storage Request channel.
@@ -22,30 +36,145 @@ func (l *Listener) GetCommunicationChannel() chan *Request {
 	return l.requestChannel
 }
 
-// Listen starts the listen process and begins servicing storage Requests until Stop is called.
+// Use appends Middleware to the chain wrapping every RequestHandler. It must be called before Listen.
+func (l *Listener) Use(middleware ...Middleware) {
+	l.Middleware = append(l.Middleware, middleware...)
+}
+
+// Listen starts the listen process and begins servicing storage Requests until Stop is called. Requests already
+// queued for a worker when Stop is called are still handled before Stop returns, while a Request which could not be
+// queued is rejected with ErrStopped.
 func (l *Listener) Listen() {
+	// Wrap the handlers once, without modifying RequestHandlers, which may be the shared HandleRequestMap
+	handlers := make(map[RequestConstant]RequestHandler, len(l.RequestHandlers))
+	for requestType, handler := range l.RequestHandlers {
+		if handler != nil {
+			handlers[requestType] = Chain(handler, l.Middleware...)
+		}
+	}
+	noop := Chain(NoopHandler, l.Middleware...)
+
+	workers := l.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queueDepth := l.QueueDepth
+	if queueDepth < 1 {
+		queueDepth = 1
+	}
+	handle := func(request *Request) {
+		handler, ok := handlers[request.RequestType]
+		switch {
+		case !ok:
+			noop(request)
+		default:
+			handler(request)
+		}
+	}
+	queues := make([]chan *Request, workers)
+	for i := range queues {
+		queues[i] = make(chan *Request, queueDepth)
+		l.wg.Add(1)
+		go func(queue chan *Request) {
+			defer l.wg.Done()
+			for request := range queue {
+				if request.RequestType == typeHold {
+					// Signal the worker is held, then wait for the held request to be handled
+					close(request.Reply)
+					<-request.Done
+					continue
+				}
+				handle(request)
+			}
+		}(queues[i])
+	}
+
 	l.wg.Add(1)
 	go func() {
+		defer l.wg.Done()
+		defer func() {
+			for _, queue := range queues {
+				close(queue)
+			}
+		}()
 		for {
 			select {
 			case request := <-l.requestChannel:
-				handler, ok := l.RequestHandlers[request.RequestType]
-				switch {
-				case !ok:
-					NoopHandler(request)
-				case handler == nil:
-					NoopHandler(request)
-				default:
-					handler(request)
+				var ok bool
+				if index, db, single := requestTarget(request); single || workers == 1 {
+					ok = l.queue(queues[xxhash.ChecksumString64(index+db)%uint64(workers)], request)
+				} else {
+					ok = l.hold(queues, request, handle)
+				}
+				if !ok {
+					Reject(request, Errorf(CodeStopped, "listener stopped before handling %v", request.RequestType))
+					return
 				}
 			case <-l.quitChannel:
-				l.wg.Done()
 				return
 			}
 		}
 	}()
 }
 
+// queue queues the Request for a worker, and returns false if the Listener is stopped first.
+func (l *Listener) queue(queue chan *Request, request *Request) bool {
+	select {
+	case queue <- request:
+		return true
+	case <-l.quitChannel:
+		return false
+	}
+}
+
+// typeHold is the request type queued for workers by hold. It is never sent to the Listener.
+const typeHold RequestConstant = -1
+
+// hold queues a hold on every worker, then handles the Request once every worker has handled the Requests queued
+// before the hold, and releases the workers. It returns false if the Listener is stopped before every hold is queued.
+func (l *Listener) hold(queues []chan *Request, request *Request, handle RequestHandler) bool {
+	release := make(chan struct{})
+	holds := make([]*Request, 0, len(queues))
+	for _, queue := range queues {
+		held := &Request{
+			RequestType: typeHold,
+			Reply:       make(chan interface{}),
+			Done:        release,
+		}
+		if !l.queue(queue, held) {
+			close(release)
+			return false
+		}
+		holds = append(holds, held)
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer close(release)
+		for _, held := range holds {
+			<-held.Reply
+		}
+		handle(request)
+	}()
+	return true
+}
+
+// requestTarget returns the Index and DB used to hash a Request to a worker, and false if the Request is not for a
+// single DB, such as an Index-level Request or a TypeBatch request with operations on several DBs. Those are handled
+// while every worker is held, so they keep their order with the Requests for every DB.
+func requestTarget(request *Request) (string, string, bool) {
+	if request.Index != "" || len(request.Operations) == 0 {
+		return request.Index, request.DB, request.DB != ""
+	}
+	index, db := request.Operations[0].Index, request.Operations[0].DB
+	for _, op := range request.Operations[1:] {
+		if op.Index != index || op.DB != db {
+			return "", "", false
+		}
+	}
+	return index, db, true
+}
+
 // Stop quits the listen process.
 func (l *Listener) Stop() {
 	close(l.quitChannel)
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Middleware wraps a RequestHandler to run code before and after it, or instead of it. A Middleware that does not
+// call the wrapped RequestHandler is responsible for closing the Reply channel of the Request, as with any
+// RequestHandler.
+type Middleware func(RequestHandler) RequestHandler
+
+// Chain wraps the RequestHandler with the given Middleware. The first Middleware is the outermost, so it sees the
+// Request first.
+func Chain(handler RequestHandler, middleware ...Middleware) RequestHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// RejectTimeout is how long Reject waits for the error to be received before dropping it.
+const RejectTimeout = 5 * time.Second
+
+// Reject responds to the Request with the error, if it has a Reply channel, and closes the Reply channel. The error
+// is dropped if it is not received within RejectTimeout, so a requester which stopped waiting cannot block the caller.
+func Reject(request *Request, err error) {
+	if request.Reply == nil {
+		return
+	}
+	request.RespondWithin(err, RejectTimeout)
+	close(request.Reply)
+}
+
+// LoggingMiddleware logs every Request and the time taken to handle it at debug level.
+func LoggingMiddleware(logger *zap.Logger) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(request *Request) {
+			start := time.Now()
+			next(request)
+			logger.Debug("handled storage request",
+				zap.String("request", request.RequestType.String()),
+				zap.String("index", request.Index),
+				zap.String("db", request.DB),
+				zap.String("entry", request.Entry),
+				zap.Duration("duration", time.Since(start)),
+			)
+		}
+	}
+}
+
+// Metrics holds the number of Requests handled and the total time spent handling them, by RequestConstant.
+type Metrics struct {
+	lock    sync.RWMutex
+	metrics map[RequestConstant]*requestMetrics
+}
+
+type requestMetrics struct {
+	count    int64
+	duration int64
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		metrics: make(map[RequestConstant]*requestMetrics),
+	}
+}
+
+func (m *Metrics) get(requestType RequestConstant) *requestMetrics {
+	m.lock.RLock()
+	rm, ok := m.metrics[requestType]
+	m.lock.RUnlock()
+	if ok {
+		return rm
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if rm, ok = m.metrics[requestType]; !ok {
+		rm = &requestMetrics{}
+		m.metrics[requestType] = rm
+	}
+	return rm
+}
+
+// Count returns the number of Requests of the given type handled.
+func (m *Metrics) Count(requestType RequestConstant) int64 {
+	return atomic.LoadInt64(&m.get(requestType).count)
+}
+
+// Duration returns the total time spent handling Requests of the given type.
+func (m *Metrics) Duration(requestType RequestConstant) time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.get(requestType).duration))
+}
+
+// MetricsMiddleware records every Request handled in the given Metrics.
+func MetricsMiddleware(metrics *Metrics) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(request *Request) {
+			start := time.Now()
+			next(request)
+			rm := metrics.get(request.RequestType)
+			atomic.AddInt64(&rm.count, 1)
+			atomic.AddInt64(&rm.duration, int64(time.Since(start)))
+		}
+	}
+}
+
+// AuthorizeMiddleware rejects any Request for an Index that allow returns false for, with ErrForbidden. The
+// operations of TypeBatch and TypeTransaction requests are checked as well. TypeFetchIndexes requests are checked
+// with an empty Index.
+func AuthorizeMiddleware(allow func(index string) bool) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(request *Request) {
+			if !allow(request.Index) {
+				Reject(request, Errorf(CodeForbidden, "%v", request.Index))
+				return
+			}
+			for _, op := range request.Operations {
+				if !allow(op.Index) {
+					Reject(request, Errorf(CodeForbidden, "%v", op.Index))
+					return
+				}
+			}
+			next(request)
+		}
+	}
+}
+
+// ValidateMiddleware rejects any Request which does not pass Check with its *ValidationError, which matches
+// ErrInvalidRequest.
+func ValidateMiddleware() Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(request *Request) {
+			if err := request.Check(); err != nil {
+				Reject(request, err)
+				return
+			}
+			next(request)
+		}
+	}
+}
+
+// RecoverMiddleware recovers from a panic in a RequestHandler, so that a single Request cannot stop the Listener.
+// The panic is logged and the Request is rejected with ErrInternal, unless its Reply channel was already closed. To
+// track that, the handler is given a copy of the Request with its own Reply channel, of the same capacity, and its
+// replies are forwarded to the Request. The Reply channel of the Request is only closed once the handler has both
+// returned and closed the one of the copy, so a handler which closes it in a deferred call before panicking is still
+// rejected with ErrInternal.
+func RecoverMiddleware(logger *zap.Logger) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(request *Request) {
+			var reply *forwardedReply
+			forward := request
+			if request.Reply != nil {
+				reply = &forwardedReply{request: request, stop: make(chan struct{})}
+				forward = reply.forward()
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("storage request handler panic",
+						zap.String("request", request.RequestType.String()),
+						zap.String("index", request.Index),
+						zap.String("panic", fmt.Sprint(r)),
+						zap.Stack("stack"),
+					)
+					if reply != nil {
+						reply.reject(Errorf(CodeInternal, "%v", r))
+					}
+				}
+			}()
+			next(forward)
+			if reply != nil {
+				reply.returned()
+			}
+		}
+	}
+}
+
+// forwardedReply forwards the replies sent to a copy of a Request to the Request, and tracks whether its Reply
+// channel is closed.
+type forwardedReply struct {
+	request *Request
+	stop    chan struct{}
+
+	// lock protects the Reply channel of the Request: closed is set once it is closed, done once the handler returned
+	// without panicking, and drained once the handler closed the Reply channel of the copy.
+	lock    sync.Mutex
+	closed  bool
+	done    bool
+	drained bool
+}
+
+// forward returns the copy of the Request with its own Reply channel, and starts forwarding its replies until the
+// handler closes it or the Request is rejected.
+func (f *forwardedReply) forward() *Request {
+	forward := *f.request
+	forward.Reply = make(chan interface{}, cap(f.request.Reply))
+	go func() {
+		for {
+			select {
+			case r, ok := <-forward.Reply:
+				f.lock.Lock()
+				if !ok {
+					f.drained = true
+					f.close()
+					f.lock.Unlock()
+					return
+				}
+				if !f.closed {
+					f.request.Respond(r)
+				}
+				f.lock.Unlock()
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+	return &forward
+}
+
+// returned records that the handler returned without panicking.
+func (f *forwardedReply) returned() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.done = true
+	f.close()
+}
+
+// close closes the Reply channel of the Request once the handler has returned and closed the Reply channel of the
+// copy. The lock must be held by the caller.
+func (f *forwardedReply) close() {
+	if f.done && f.drained && !f.closed {
+		f.closed = true
+		close(f.request.Reply)
+	}
+}
+
+// reject rejects the Request with the error, unless its Reply channel is already closed, and stops forwarding the
+// replies of the copy, so any later replies are dropped.
+func (f *forwardedReply) reject(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.closed {
+		f.closed = true
+		Reject(f.request, err)
+	}
+	close(f.stop)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler RequestHandler
+		want    error
+	}{
+		{
+			name: "panic without closing Reply",
+			handler: func(request *Request) {
+				panic("no close")
+			},
+			want: ErrInternal,
+		},
+		{
+			name: "panic with a deferred close",
+			handler: func(request *Request) {
+				defer close(request.Reply)
+				panic("deferred close")
+			},
+			want: ErrInternal,
+		},
+		{
+			name: "reply with a deferred close",
+			handler: func(request *Request) {
+				defer close(request.Reply)
+				request.Respond("ok")
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := RecoverMiddleware(zap.NewNop())(test.handler)
+			before := runtime.NumGoroutine()
+			// The deferred close races the rejection, so repeat to catch the forwarded close winning
+			for i := 0; i < 100; i++ {
+				request := &Request{RequestType: TypeFetchIndexes, Reply: make(chan interface{}, 1)}
+				handler(request)
+				var replies []interface{}
+				for reply := range request.Reply {
+					replies = append(replies, reply)
+				}
+				if len(replies) != 1 {
+					t.Fatalf("got replies %v", replies)
+				}
+				err, _ := replies[0].(error)
+				if test.want == nil && replies[0] != "ok" || test.want != nil && !errors.Is(err, test.want) {
+					t.Fatalf("got reply %v, want %v", replies[0], test.want)
+				}
+			}
+			// Every forwarding goroutine exits
+			for i := 0; runtime.NumGoroutine() > before; i++ {
+				if i == 100 {
+					t.Fatalf("%d goroutines left running", runtime.NumGoroutine()-before)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
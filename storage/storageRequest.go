@@ -1,14 +1,15 @@
 package storage
 
 import (
-	"fmt"
+	"context"
 	"time"
 )
 
 // Request is sent over the StorageChannel that is stored in the application context. It is a query to either
 // send information to the storage subsystem, or retrieve information from it . The RequestType indiciates the
 // particular type of request. "Set" and "Clear" requests do not get a response. "Fetch" requests will send a response
-// over the Reply channel supplied in the request
+// over the Reply channel supplied in the request. If a "Fetch" request fails, an error is sent instead, which can be
+// tested using errors.Is against the storage errors, such as ErrUnknownEntry
 type Request struct {
 	// The type of request that this struct encapsulates
 	RequestType RequestConstant
@@ -16,6 +17,9 @@ type Request struct {
 	// If the RequestType is a "Fetch" request, Reply must contain a channel to receive the response on
 	Reply chan interface{}
 
+	// If the RequestType is a "Watch" request, Done must contain a channel which is closed to cancel the watch
+	Done chan struct{}
+
 	// The name of the cluster to which the request applies. Required for all request types except StorageFetchClusters
 	Index string
 
@@ -28,8 +32,38 @@ type Request struct {
 	// The timestamp of the request
 	Timestamp int64
 
+	// TTL is the time to live for the Entry of a TypeSetEntry request. Zero means the Entry does not expire.
+	TTL time.Duration
+
+	// DBTTL is the time a DB is kept without being accessed, set by a TypeSetEntry request. Zero keeps the
+	// default of the storage module.
+	DBTTL time.Duration
+
+	// The expected current version of the Entry for a TypeCompareAndSet request, or for an operation of a
+	// TypeTransaction request if CheckVersion is set
+	Version uint64
+
+	// If set, an operation of a TypeTransaction request fails unless the Entry is at Version. A Version of 0 requires
+	// that the Entry does not exist
+	CheckVersion bool
+
+	// The operations of a TypeBatch or TypeTransaction request, applied in order
+	Operations []*Request
+
+	// The range, limit and cursor of a TypeScan request
+	Scan ScanOptions
+
+	// The secondary index of a TypeSetSecondaryIndex or TypeFetchByIndex request
+	Secondary SecondaryIndex
+
+	// The ID of a snapshot created by TypeSnapshot, for a fetch request to read from or a TypeReleaseSnapshot
+	// request to release
+	Snapshot string
+
 	// Interface holding data
 	Object
+
+	ctx context.Context
 }
 
 // RequestBuilder helps build a Request using chains.
@@ -41,6 +75,9 @@ type RequestBuilder struct {
 	// If the RequestType is a "Fetch" request, Reply must contain a channel to receive the response on
 	Reply chan interface{}
 
+	// If the RequestType is a "Watch" request, Done must contain a channel which is closed to cancel the watch
+	Done chan struct{}
+
 	// The name of the cluster to which the request applies. Required for all request types except StorageFetchClusters
 	Index string
 
@@ -53,8 +90,38 @@ type RequestBuilder struct {
 	// The timestamp of the request
 	Timestamp int64
 
+	// TTL is the time to live for the Entry of a TypeSetEntry request. Zero means the Entry does not expire.
+	TTL time.Duration
+
+	// DBTTL is the time a DB is kept without being accessed, set by a TypeSetEntry request. Zero keeps the
+	// default of the storage module.
+	DBTTL time.Duration
+
+	// The expected current version of the Entry for a TypeCompareAndSet request, or for an operation of a
+	// TypeTransaction request if CheckVersion is set
+	Version uint64
+
+	// If set, an operation of a TypeTransaction request fails unless the Entry is at Version. A Version of 0 requires
+	// that the Entry does not exist
+	CheckVersion bool
+
+	// The operations of a TypeBatch or TypeTransaction request, applied in order
+	Operations []*Request
+
+	// The range, limit and cursor of a TypeScan request
+	Scan ScanOptions
+
+	// The secondary index of a TypeSetSecondaryIndex or TypeFetchByIndex request
+	Secondary SecondaryIndex
+
+	// The ID of a snapshot created by TypeSnapshot, for a fetch request to read from or a TypeReleaseSnapshot
+	// request to release
+	Snapshot string
+
 	// Interface holding data
 	Object
+
+	ctx context.Context
 }
 
 // Object is the interface which references the data you want to store.
@@ -87,13 +154,22 @@ func BuildRequest() *RequestBuilder {
 // SetRequestType sets the Corresponding Request Type.
 func (sr *RequestBuilder) SetRequestType(requestType RequestConstant) *RequestBuilder {
 	switch requestType {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry:
+	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry, TypeFetchDatabases, TypeScan, TypeFetchByIndex,
+		TypeBatch, TypeTransaction, TypeCompareAndSet, TypeIncrement, TypeDecrement, TypeSnapshot:
 		sr.Reply = make(chan interface{})
+	case TypeWatch:
+		sr.Reply = make(chan interface{}, WatchBufferSize)
+		sr.Done = make(chan struct{})
 	}
 	sr.RequestType = requestType
 	return sr
 }
 
+// SetRequestWatch sets the Request Type to TypeWatch. The Entry, if set, is used as a key prefix.
+func (sr *RequestBuilder) SetRequestWatch() *RequestBuilder {
+	return sr.SetRequestType(TypeWatch)
+}
+
 /*
 // SetRequestSetIndex sets the Corresponding Request Type.
 func (sr *RequestBuilder) SetRequestSetIndex() *RequestBuilder {
@@ -159,142 +235,186 @@ func (sr *RequestBuilder) SetObject(obj Object) *RequestBuilder {
 	return sr
 }
 
+// AddOperation adds operations to a TypeBatch or TypeTransaction Storage Request. Operations are applied in the order they are added.
+// Any Reply channel on an operation is discarded, as results are returned with the batch.
+func (sr *RequestBuilder) AddOperation(ops ...*RequestBuilder) *RequestBuilder {
+	for _, op := range ops {
+		op.Reply = nil
+		sr.Operations = append(sr.Operations, convertFromBuilder(op))
+	}
+	return sr
+}
+
+// SetVersion sets the expected current version of the Entry for a TypeCompareAndSet Storage Request.
+func (sr *RequestBuilder) SetVersion(version uint64) *RequestBuilder {
+	sr.Version = version
+	return sr
+}
+
+// SetScan sets the range, limit and cursor for a TypeScan Storage Request.
+func (sr *RequestBuilder) SetScan(scan ScanOptions) *RequestBuilder {
+	sr.Scan = scan
+	return sr
+}
+
+// SetSecondaryIndex sets the name and extract function of the secondary index for a TypeSetSecondaryIndex Storage
+// Request.
+func (sr *RequestBuilder) SetSecondaryIndex(name string, extract IndexFunc) *RequestBuilder {
+	sr.Secondary.Name = name
+	sr.Secondary.Extract = extract
+	return sr
+}
+
+// SetIndexKey sets the name of the secondary index and the key to look up for a TypeFetchByIndex Storage Request.
+func (sr *RequestBuilder) SetIndexKey(name, key string) *RequestBuilder {
+	sr.Secondary.Name = name
+	sr.Secondary.Key = key
+	return sr
+}
+
+// IfVersion makes an operation of a TypeTransaction Storage Request fail unless the Entry is at the given version.
+// A version of 0 requires that the Entry does not exist.
+func (sr *RequestBuilder) IfVersion(version uint64) *RequestBuilder {
+	sr.Version = version
+	sr.CheckVersion = true
+	return sr
+}
+
+// SetContext sets the context for the Storage Request. The storage module skips a Request with a Reply if the context
+// is done before it is handled, and stops waiting to send a response once the context is done. Requests without a
+// Reply are always handled.
+func (sr *RequestBuilder) SetContext(ctx context.Context) *RequestBuilder {
+	sr.ctx = ctx
+	return sr
+}
+
+// SetTTL sets the time to live for the Entry of the Storage Request.
+func (sr *RequestBuilder) SetTTL(ttl time.Duration) *RequestBuilder {
+	sr.TTL = ttl
+	return sr
+}
+
+// SetDBTTL sets the time the DB of the Storage Request is kept without being accessed.
+func (sr *RequestBuilder) SetDBTTL(ttl time.Duration) *RequestBuilder {
+	sr.DBTTL = ttl
+	return sr
+}
+
+// SetSnapshot sets the snapshot ID for the Storage Request, so a fetch request reads from the snapshot.
+func (sr *RequestBuilder) SetSnapshot(id string) *RequestBuilder {
+	sr.Snapshot = id
+	return sr
+}
+
+// SetTimestamp sets the timestamp for the Storage Request, in Unix nanoseconds.
+func (sr *RequestBuilder) SetTimestamp(timestamp int64) *RequestBuilder {
+	sr.Timestamp = timestamp
+	return sr
+}
+
+// Check validates the RequestBuilder and returns a *ValidationError describing every missing or forbidden field, or
+// nil if it is valid.
+func (sr *RequestBuilder) Check() error {
+	return convertFromBuilder(sr).Check()
+}
+
+// Build validates the RequestBuilder and returns the converted Request, or a *ValidationError.
+func (sr *RequestBuilder) Build() (*Request, error) {
+	request := convertFromBuilder(sr)
+	return request, request.Check()
+}
+
 // Validate validates the RequestBuilder for all fields and returns
 // back a converted Request and true if valdation passes.
+// Use Check or Build to find out why validation failed.
 func (sr *RequestBuilder) Validate() (*Request, bool) {
-validateRequest:
-	switch sr.RequestType {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry:
-		switch {
-		case sr.Reply == nil:
-			fmt.Println("1")
-			break validateRequest
-		case sr.DB == "" || sr.Index == "":
-			fmt.Println("2")
-			if sr.RequestType == TypeFetchEntries || sr.RequestType == TypeFetchEntry {
-				break validateRequest
-			}
-			fallthrough
-		case sr.Entry == "":
-			fmt.Println("3")
-			if sr.RequestType == TypeFetchEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType == TypeFetchIndexes {
-				if sr.DB != "" || sr.Index != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			if sr.RequestType == TypeFetchEntries {
-				if sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			fmt.Println("4:", sr.RequestType)
-			return convertFromBuilder(sr), true
-		}
-	case TypeSetIndex, TypeSetEntry, TypeDeleteEntry:
-		switch {
-		case sr.Reply != nil:
-			fmt.Println("1")
-			break validateRequest
-		case sr.Index == "":
-			fmt.Println("2")
-			break validateRequest
-		case sr.DB == "" || sr.Entry == "":
-			fmt.Println("3")
-			if sr.RequestType == TypeSetEntry || sr.RequestType == TypeDeleteEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType == TypeSetIndex {
-				if sr.DB != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			fmt.Println("4:", sr.RequestType)
-			return convertFromBuilder(sr), true
-		}
-	}
-	return convertFromBuilder(sr), false
+	request, err := sr.Build()
+	return request, err == nil
 }
 
 func convertFromBuilder(sr *RequestBuilder) *Request {
 	return &Request{
-		RequestType: sr.RequestType,
-		Reply:       sr.Reply,
-		Index:       sr.Index,
-		DB:          sr.DB,
-		Entry:       sr.Entry,
-		Timestamp:   sr.Timestamp,
-		Object:      sr.Object,
+		RequestType:  sr.RequestType,
+		Reply:        sr.Reply,
+		Done:         sr.Done,
+		Index:        sr.Index,
+		DB:           sr.DB,
+		Entry:        sr.Entry,
+		Timestamp:    sr.Timestamp,
+		TTL:          sr.TTL,
+		DBTTL:        sr.DBTTL,
+		Version:      sr.Version,
+		CheckVersion: sr.CheckVersion,
+		Operations:   sr.Operations,
+		Scan:         sr.Scan,
+		Secondary:    sr.Secondary,
+		Snapshot:     sr.Snapshot,
+		Object:       sr.Object,
+		ctx:          sr.ctx,
 	}
 }
 
 // Validate validates the Storage Request for all fields and returns
 // it back and true if valdation passes.
+// Use Check to find out why validation failed.
 func (sr *Request) Validate() (*Request, bool) {
-validateRequest:
-	switch sr.RequestType {
-	case TypeFetchIndexes, TypeFetchEntries, TypeFetchEntry:
-		switch {
-		case sr.Reply == nil:
-			fmt.Println("1")
-			break validateRequest
-		case sr.DB == "" || sr.Index == "":
-			fmt.Println("2")
-			if sr.RequestType == TypeFetchEntries || sr.RequestType == TypeFetchEntry {
-				break validateRequest
-			}
-			fallthrough
-		case sr.Entry == "":
-			fmt.Println("3")
-			if sr.RequestType == TypeFetchEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType == TypeFetchIndexes {
-				if sr.DB != "" || sr.Index != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			if sr.RequestType == TypeFetchEntries {
-				if sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			fmt.Println("4:", sr.RequestType)
-			return sr, true
-		}
-	case TypeSetIndex, TypeSetEntry, TypeDeleteEntry:
-		switch {
-		case sr.Reply != nil:
-			fmt.Println("1")
-			break validateRequest
-		case sr.Index == "":
-			fmt.Println("2")
-			break validateRequest
-		case sr.DB == "" || sr.Entry == "":
-			fmt.Println("3")
-			if sr.RequestType == TypeSetEntry || sr.RequestType == TypeDeleteEntry {
-				break validateRequest
-			}
-			fallthrough
-		default:
-			if sr.RequestType == TypeSetIndex {
-				if sr.DB != "" || sr.Entry != "" {
-					break validateRequest
-				}
-			}
-			fmt.Println("4:", sr.RequestType)
-			return sr, true
-		}
+	return sr, sr.Check() == nil
+}
+
+func (sr *Request) Context() context.Context {
+	if sr.ctx != nil {
+		return sr.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the Request with its context set to ctx.
+func (sr *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r := *sr
+	r.ctx = ctx
+	return &r
+}
+
+// Respond sends a response over the Reply channel. It returns false without sending if the context of the Request
+// is done before the response is received, so a handler never blocks on a caller that stopped waiting.
+func (sr *Request) Respond(response interface{}) bool {
+	select {
+	case sr.Reply <- response:
+		return true
+	case <-sr.Context().Done():
+		return false
+	}
+}
+
+// RespondWithin sends a response over the Reply channel like Respond, but also returns false without sending if the
+// response is not received within the timeout.
+func (sr *Request) RespondWithin(response interface{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case sr.Reply <- response:
+		return true
+	case <-sr.Context().Done():
+		return false
+	case <-timer.C:
+		return false
+	}
+}
+
+// Cancel cancels a TypeWatch Request by closing its Done channel. The storage module then closes the Reply channel.
+// Calling Cancel more than once is safe, but it must not be called concurrently.
+func (sr *Request) Cancel() {
+	if sr.Done == nil {
+		return
+	}
+	select {
+	case <-sr.Done:
+	default:
+		close(sr.Done)
 	}
-	return sr, false
 }
 
 // TimeoutSendStorageRequest sends a Request to a channel with a timeout,
@@ -1,8 +1,86 @@
 package storage
 
+import "context"
+
 // Response contains the response from a Request
 type Response struct {
 	Failure   bool
 	HasObject bool
 	Object
+
+	// TimedOut is true if the Request could not be sent or no reply was received in time.
+	// A Request that was answered without an Object, such as a fetch for an unknown Entry, is not timed out.
+	TimedOut bool
+
+	// Data holds the full Data, including version information, if one was returned.
+	Data *Data
+
+	// Reply holds the value received over the Reply channel, such as the []string of a TypeFetchEntries Request.
+	Reply interface{}
+
+	// Err holds any error returned by the storage module, or the reason the Request failed.
+	// It can be tested using errors.Is against the storage errors, such as ErrUnknownEntry or ErrTimeout.
+	Err error
+}
+
+// SetReply fills in the Response from a value received over the Reply channel of a Request.
+func (response *Response) SetReply(r interface{}) {
+	response.Failure = false
+	response.Reply = r
+	switch r := r.(type) {
+	case *Data:
+		if r != nil {
+			response.Object = r.Object
+			response.Data = r
+			response.HasObject = true
+		}
+	case error:
+		response.Failure = true
+		response.Err = r
+	}
+}
+
+// setTimeout marks the Response as timed out with an error wrapping the context error.
+func (response *Response) setTimeout(ctx context.Context, format string, v ...interface{}) {
+	err := Errorf(CodeTimeout, format, v...)
+	err.Err = ctx.Err()
+	response.Failure = true
+	response.TimedOut = true
+	response.Err = err
+}
+
+// SendRequestContext sends a Request to a storage channel and waits for any reply.
+// The context covers both sending the request and waiting for the reply. If it is done first, the Response
+// has TimedOut set and Err matches both ErrTimeout and the context error. The storage module skips a request with
+// a Reply if the context is done before the request is handled. Writes, which have no Reply, are always applied once
+// sent, even if the context is done first.
+func SendRequestContext(ctx context.Context, storageChannel chan *Request, sr *Request) *Response {
+	var response Response
+	if err := sr.Check(); err != nil {
+		response.Failure = true
+		response.Err = err
+		return &response
+	}
+	sr = sr.WithContext(ctx)
+	select {
+	case storageChannel <- sr:
+	case <-ctx.Done():
+		response.setTimeout(ctx, "sending %v", sr.RequestType)
+		return &response
+	}
+	if sr.Reply == nil {
+		return &response
+	}
+	select {
+	case r, ok := <-sr.Reply:
+		if !ok && ctx.Err() != nil {
+			// The storage module skipped the request as the context was done before it was handled
+			response.setTimeout(ctx, "waiting for %v", sr.RequestType)
+			break
+		}
+		response.SetReply(r)
+	case <-ctx.Done():
+		response.setTimeout(ctx, "waiting for %v", sr.RequestType)
+	}
+	return &response
 }
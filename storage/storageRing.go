@@ -0,0 +1,59 @@
+package storage
+
+import "strconv"
+
+// RingPoint is a timestamped value stored in a Ring. The Timestamp is in Unix nanoseconds.
+type RingPoint struct {
+	Timestamp int64
+	Object
+}
+
+// Ring is an Object holding the most recent values appended to an Entry by TypeAppendEntry requests, up to Size
+// points. Points are ordered from oldest to newest. A Ring is never modified once stored, appending returns a new
+// Ring, so the window returned by TypeFetchEntry can be read without holding any lock.
+type Ring struct {
+	Size   int
+	Points []RingPoint
+}
+
+// ID returns the timestamp of the newest point, or an empty string if the Ring is empty.
+func (r *Ring) ID() string {
+	if len(r.Points) == 0 {
+		return ""
+	}
+	return strconv.FormatInt(r.Points[len(r.Points)-1].Timestamp, 10)
+}
+
+// Last returns the newest point and true, or false if the Ring is empty.
+func (r *Ring) Last() (RingPoint, bool) {
+	if len(r.Points) == 0 {
+		return RingPoint{}, false
+	}
+	return r.Points[len(r.Points)-1], true
+}
+
+// Append returns a new Ring of the given size with the point added, dropping the oldest points as needed. The point
+// is dropped instead, and false returned, if it is less than minDistance nanoseconds after the newest point. A nil
+// Ring is treated as empty.
+func (r *Ring) Append(point RingPoint, size int, minDistance int64) (*Ring, bool) {
+	if size < 1 {
+		size = 1
+	}
+	var points []RingPoint
+	if r != nil {
+		if last, ok := r.Last(); ok && point.Timestamp-last.Timestamp < minDistance {
+			return r, false
+		}
+		points = r.Points
+	}
+	if len(points) >= size {
+		points = points[len(points)-size+1:]
+	}
+	next := &Ring{
+		Size:   size,
+		Points: make([]RingPoint, len(points), len(points)+1),
+	}
+	copy(next.Points, points)
+	next.Points = append(next.Points, point)
+	return next, true
+}
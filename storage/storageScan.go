@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+)
+
+// DefaultScanLimit is the number of entries returned by a TypeScan request which does not set a Limit.
+var DefaultScanLimit = 1000
+
+// ScanOptions selects the entries returned by a TypeScan request. Keys are returned in sorted order. Prefix and the
+// [Start,End) range may be combined, in which case an entry must match both. An empty End means no upper bound.
+type ScanOptions struct {
+	// Prefix limits the scan to keys beginning with Prefix.
+	Prefix string
+
+	// Start is the first key of the range, inclusive.
+	Start string
+
+	// End is the last key of the range, exclusive.
+	End string
+
+	// Limit is the maximum number of entries returned. Zero uses DefaultScanLimit.
+	Limit int
+
+	// Cursor resumes a scan after the last entry returned. It is taken from the ScanResult of the previous page.
+	Cursor string
+}
+
+// ScanEntry is a single Entry returned by a TypeScan request.
+type ScanEntry struct {
+	Entry string
+	Data  *Data
+}
+
+// ScanResult is the reply to a TypeScan request.
+type ScanResult struct {
+	Entries []*ScanEntry
+
+	// Cursor is set when more entries remain, and is passed in the ScanOptions of the next request to fetch them.
+	Cursor string
+}
+
+// More returns true if more entries remain after this page.
+func (r *ScanResult) More() bool {
+	return r.Cursor != ""
+}
+
+// valid returns true if the ScanOptions can be used for a scan.
+func (s *ScanOptions) valid() bool {
+	if s.Limit < 0 || (s.End != "" && s.Start >= s.End) {
+		return false
+	}
+	_, err := decodeCursor(s.Cursor)
+	return err == nil
+}
+
+// Page returns the next page of the scan from the sorted keys. Entries for which get returns false, such as expired
+// entries, are skipped. Since the position is carried in the cursor as a key, no state is kept between pages and
+// the keys may change in between.
+func (s *ScanOptions) Page(keys []string, get func(entry string) (*Data, bool)) (*ScanResult, error) {
+	after, err := decodeCursor(s.Cursor)
+	if err != nil {
+		return nil, Errorf(CodeInvalidRequest, "invalid cursor: %v", err)
+	}
+	limit := s.Limit
+	if limit == 0 {
+		limit = DefaultScanLimit
+	}
+
+	start := s.Start
+	if s.Prefix > start {
+		start = s.Prefix
+	}
+	i := sort.SearchStrings(keys, start)
+	if s.Cursor != "" && after >= start {
+		i = sort.Search(len(keys), func(n int) bool { return keys[n] > after })
+	}
+
+	result := &ScanResult{}
+	for ; i < len(keys); i++ {
+		key := keys[i]
+		if (s.End != "" && key >= s.End) || !strings.HasPrefix(key, s.Prefix) {
+			break
+		}
+		data, ok := get(key)
+		if !ok {
+			continue
+		}
+		if len(result.Entries) == limit {
+			result.Cursor = encodeCursor(result.Entries[limit-1].Entry)
+			break
+		}
+		result.Entries = append(result.Entries, &ScanEntry{
+			Entry: key,
+			Data:  data,
+		})
+	}
+	return result, nil
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(key), err
+}
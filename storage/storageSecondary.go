@@ -0,0 +1,13 @@
+package storage
+
+// IndexFunc returns the secondary index keys for an Object, such as its owner or status. An Object may have any
+// number of keys, including none, in which case it is not indexed.
+type IndexFunc func(Object) []string
+
+// SecondaryIndex names a secondary index of a DB. Extract is set by a TypeSetSecondaryIndex request to declare the
+// index, and Key by a TypeFetchByIndex request to look up the entries indexed under it.
+type SecondaryIndex struct {
+	Name    string
+	Key     string
+	Extract IndexFunc
+}
@@ -0,0 +1,17 @@
+package storage
+
+// TransactionResult is sent over the Reply channel of a TypeTransaction Request. Results holds one OperationResult
+// for each operation that was applied, in order. If the transaction was not committed, the last result holds the
+// error of the operation which failed, and none of the changes were kept.
+type TransactionResult struct {
+	Committed bool
+	Results   []*OperationResult
+}
+
+// Failed returns the result of the operation which aborted the transaction, or nil if it was committed.
+func (t *TransactionResult) Failed() *OperationResult {
+	if t.Committed || len(t.Results) == 0 {
+		return nil
+	}
+	return t.Results[len(t.Results)-1]
+}
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldRule lists the fields a request type requires and the fields it forbids.
+type FieldRule struct {
+	Required  []string
+	Forbidden []string
+}
+
+// ValidationError describes every reason a request failed validation. It matches ErrInvalidRequest using errors.Is.
+type ValidationError struct {
+	RequestType string
+
+	// Missing and Forbidden hold the names of required fields which are not set and forbidden fields which are set.
+	Missing   []string
+	Forbidden []string
+
+	// Invalid holds any other problems, such as a negative TTL.
+	Invalid []string
+}
+
+// Error returns the error string, listing each missing or forbidden field.
+func (e *ValidationError) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Forbidden) > 0 {
+		problems = append(problems, "forbidden "+strings.Join(e.Forbidden, ", "))
+	}
+	problems = append(problems, e.Invalid...)
+	return fmt.Sprintf("%v %v: %v", ErrCodeMap[CodeInvalidRequest], e.RequestType, strings.Join(problems, "; "))
+}
+
+// Unwrap returns ErrInvalidRequest.
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// Validator collects the validation failures of a request. It is shared by every package defining requests, which
+// describe each of their request types with a FieldRule.
+type Validator struct {
+	err ValidationError
+}
+
+// NewValidator returns a Validator for a request of the given type.
+func NewValidator(requestType fmt.Stringer) *Validator {
+	return &Validator{
+		err: ValidationError{RequestType: requestType.String()},
+	}
+}
+
+// CheckFields checks the fields which are set against the FieldRule. Field names are reported with the given
+// prefix, such as the position of an operation.
+func (v *Validator) CheckFields(prefix string, rule FieldRule, set map[string]bool) {
+	for _, field := range rule.Required {
+		if !set[field] {
+			v.err.Missing = append(v.err.Missing, prefix+field)
+		}
+	}
+	for _, field := range rule.Forbidden {
+		if set[field] {
+			v.err.Forbidden = append(v.err.Forbidden, prefix+field)
+		}
+	}
+}
+
+// Invalidf records a problem other than a missing or forbidden field.
+func (v *Validator) Invalidf(format string, a ...interface{}) {
+	v.err.Invalid = append(v.err.Invalid, fmt.Sprintf(format, a...))
+}
+
+// Err returns a *ValidationError if any problem was found, or nil.
+func (v *Validator) Err() error {
+	if len(v.err.Missing) == 0 && len(v.err.Forbidden) == 0 && len(v.err.Invalid) == 0 {
+		return nil
+	}
+	err := v.err
+	return &err
+}
+
+// requestRules describes the fields of each request type.
+var requestRules = map[RequestConstant]FieldRule{
+	TypeSetIndex: {
+		Required:  []string{"Index"},
+		Forbidden: []string{"Reply", "DB", "Entry"},
+	},
+	TypeSetEntry: {
+		Required:  []string{"Index", "DB", "Entry"},
+		Forbidden: []string{"Reply"},
+	},
+	TypeDeleteEntry: {
+		Required:  []string{"Index", "DB", "Entry"},
+		Forbidden: []string{"Reply"},
+	},
+	TypeFetchIndexes: {
+		Required:  []string{"Reply"},
+		Forbidden: []string{"Index", "DB", "Entry"},
+	},
+	TypeFetchEntries: {
+		Required:  []string{"Reply", "Index", "DB"},
+		Forbidden: []string{"Entry"},
+	},
+	TypeFetchEntry: {
+		Required: []string{"Reply", "Index", "DB", "Entry"},
+	},
+	TypeWatch: {
+		Required: []string{"Reply", "Done", "Index"},
+	},
+	TypeBatch: {
+		Required:  []string{"Reply", "Operations"},
+		Forbidden: []string{"Index", "DB", "Entry"},
+	},
+	TypeCompareAndSet: {
+		Required: []string{"Reply", "Index", "DB", "Entry"},
+	},
+	TypeDeleteDB: {
+		Required:  []string{"Index", "DB"},
+		Forbidden: []string{"Reply", "Entry"},
+	},
+	TypeDeleteIndex: {
+		Required:  []string{"Index"},
+		Forbidden: []string{"Reply", "DB", "Entry"},
+	},
+	TypeFetchDatabases: {
+		Required:  []string{"Reply", "Index"},
+		Forbidden: []string{"DB", "Entry"},
+	},
+	TypeScan: {
+		Required:  []string{"Reply", "Index", "DB"},
+		Forbidden: []string{"Entry"},
+	},
+	TypeSetSecondaryIndex: {
+		Required:  []string{"Index", "DB", "Secondary.Name"},
+		Forbidden: []string{"Reply", "Entry", "Secondary.Key"},
+	},
+	TypeFetchByIndex: {
+		Required:  []string{"Reply", "Index", "DB", "Secondary.Name"},
+		Forbidden: []string{"Entry", "Secondary.Extract"},
+	},
+	TypeIncrement: {
+		Required: []string{"Reply", "Index", "DB", "Entry", "Object"},
+	},
+	TypeDecrement: {
+		Required: []string{"Reply", "Index", "DB", "Entry", "Object"},
+	},
+	TypeTransaction: {
+		Required:  []string{"Reply", "Index", "Operations"},
+		Forbidden: []string{"DB", "Entry"},
+	},
+	TypeAppendEntry: {
+		Required:  []string{"Index", "DB", "Entry", "Object"},
+		Forbidden: []string{"Reply"},
+	},
+	TypeSnapshot: {
+		Required:  []string{"Reply"},
+		Forbidden: []string{"DB", "Entry", "Snapshot"},
+	},
+	TypeReleaseSnapshot: {
+		Required:  []string{"Snapshot"},
+		Forbidden: []string{"Reply", "Index", "DB", "Entry"},
+	},
+}
+
+// RequestRules returns the FieldRule of the request type with the given name, such as "TypeSetEntry", so packages
+// defining their own request types can validate those they share with this package by the same rules.
+func RequestRules(name string) (FieldRule, bool) {
+	for c, s := range storageRequestStrings {
+		if s != name {
+			continue
+		}
+		rule, ok := requestRules[RequestConstant(c)]
+		if !ok {
+			return FieldRule{}, false
+		}
+		return FieldRule{
+			Required:  append([]string(nil), rule.Required...),
+			Forbidden: append([]string(nil), rule.Forbidden...),
+		}, true
+	}
+	return FieldRule{}, false
+}
+
+// snapshotReads holds the request types which may read from a snapshot.
+var snapshotReads = map[RequestConstant]bool{
+	TypeFetchIndexes:    true,
+	TypeFetchDatabases:  true,
+	TypeFetchEntries:    true,
+	TypeFetchEntry:      true,
+	TypeScan:            true,
+	TypeReleaseSnapshot: true,
+}
+
+// operationRules describes the fields of each request type allowed as an operation of a TypeBatch or
+// TypeTransaction request. Results are returned with the request, so operations have no Reply.
+var operationRules = map[RequestConstant]FieldRule{
+	TypeSetEntry: {
+		Required:  []string{"Index", "DB", "Entry"},
+		Forbidden: []string{"Reply"},
+	},
+	TypeDeleteEntry: {
+		Required:  []string{"Index", "DB", "Entry"},
+		Forbidden: []string{"Reply"},
+	},
+	TypeFetchEntry: {
+		Required:  []string{"Index", "DB", "Entry"},
+		Forbidden: []string{"Reply"},
+	},
+	TypeFetchEntries: {
+		Required:  []string{"Index", "DB"},
+		Forbidden: []string{"Reply", "Entry"},
+	},
+}
+
+// fields returns the names of the fields of the Request which are set.
+func (sr *Request) fields() map[string]bool {
+	return map[string]bool{
+		"Reply":             sr.Reply != nil,
+		"Done":              sr.Done != nil,
+		"Index":             sr.Index != "",
+		"DB":                sr.DB != "",
+		"Entry":             sr.Entry != "",
+		"Object":            sr.Object != nil,
+		"Operations":        len(sr.Operations) > 0,
+		"Secondary.Name":    sr.Secondary.Name != "",
+		"Secondary.Key":     sr.Secondary.Key != "",
+		"Secondary.Extract": sr.Secondary.Extract != nil,
+		"Snapshot":          sr.Snapshot != "",
+	}
+}
+
+// Check validates the Request and returns a *ValidationError describing every problem found, or nil if it is valid.
+func (sr *Request) Check() error {
+	v := NewValidator(sr.RequestType)
+	rule, ok := requestRules[sr.RequestType]
+	if !ok {
+		v.Invalidf("unknown request type %d", sr.RequestType)
+		return v.Err()
+	}
+	v.CheckFields("", rule, sr.fields())
+	if sr.TTL < 0 {
+		v.Invalidf("TTL must not be negative")
+	}
+	if sr.DBTTL < 0 {
+		v.Invalidf("DBTTL must not be negative")
+	}
+	if sr.Timestamp < 0 {
+		v.Invalidf("Timestamp must not be negative")
+	}
+	if sr.Snapshot != "" && !snapshotReads[sr.RequestType] {
+		v.Invalidf("Snapshot is not allowed for %v", sr.RequestType)
+	}
+
+	switch sr.RequestType {
+	case TypeWatch:
+		if sr.Entry != "" && sr.DB == "" {
+			v.Invalidf("Entry prefix requires DB")
+		}
+	case TypeScan:
+		if !sr.Scan.valid() {
+			v.Invalidf("Scan requires a non-negative Limit, Start before End and a valid Cursor")
+		}
+	case TypeIncrement, TypeDecrement:
+		if sr.Object != nil && !isCounter(sr.Object) {
+			v.Invalidf("Object must be an Int64 or Float64, not %T", sr.Object)
+		}
+	case TypeBatch, TypeTransaction:
+		for i, op := range sr.Operations {
+			sr.checkOperation(v, i, op)
+		}
+	}
+	return v.Err()
+}
+
+// checkOperation validates an operation of a TypeBatch or TypeTransaction Request.
+func (sr *Request) checkOperation(v *Validator, i int, op *Request) {
+	prefix := fmt.Sprintf("Operations[%d].", i)
+	if op == nil {
+		v.Invalidf("%vRequest must not be nil", prefix)
+		return
+	}
+	rule, ok := operationRules[op.RequestType]
+	if !ok {
+		v.Invalidf("%vRequestType %v is not allowed", prefix, op.RequestType)
+		return
+	}
+	v.CheckFields(prefix, rule, op.fields())
+	if op.Snapshot != "" {
+		v.Invalidf("%vSnapshot is not allowed", prefix)
+	}
+	if op.TTL < 0 || op.DBTTL < 0 {
+		v.Invalidf("%vTTL must not be negative", prefix)
+	}
+	switch {
+	case sr.RequestType == TypeBatch && op.CheckVersion:
+		v.Invalidf("%vCheckVersion is only allowed in a TypeTransaction", prefix)
+	case sr.RequestType == TypeTransaction && op.CheckVersion && op.RequestType == TypeFetchEntries:
+		v.Invalidf("%vCheckVersion is not allowed for TypeFetchEntries", prefix)
+	}
+	if sr.RequestType == TypeTransaction && op.Index != "" && op.Index != sr.Index {
+		v.Invalidf("%vIndex must match the transaction Index %q", prefix, sr.Index)
+	}
+}
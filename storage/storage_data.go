@@ -3,6 +3,20 @@ package storage
 // Data holds the storage Object
 type Data struct {
 	Object
+
+	// Version is assigned by the storage module each time the Entry is stored, and always increases.
+	Version uint64
+
+	// Modified is the time, in Unix nanoseconds, the Entry was stored.
+	Modified int64
+
+	// Expires is the time, in Unix nanoseconds, after which the Data is expired. Zero means it never expires.
+	Expires int64
+}
+
+// Expired returns true if the Data is expired at the given time, in Unix nanoseconds.
+func (d *Data) Expired(now int64) bool {
+	return d.Expires > 0 && now >= d.Expires
 }
 
 /*
@@ -0,0 +1,307 @@
+package tiered
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jbvmio/modules/coop"
+	"github.com/jbvmio/modules/storage"
+	"github.com/jbvmio/modules/storage/disk"
+	"github.com/jbvmio/modules/storage/inmemory"
+	"github.com/spf13/viper"
+
+	"go.uber.org/zap"
+)
+
+const (
+	moduleName  = `tiered`
+	moduleClass = `tiered`
+)
+
+// Write modes.
+const (
+	WriteThrough = `through`
+	WriteBehind  = `behind`
+)
+
+// TieredModule is a storage module that keeps a hot tier of entries in an InMemoryModule over a cold tier holding the
+// whole data set in a DiskModule. Entries are fetched from the hot tier, and on a miss from the cold tier, after which
+// they are promoted to the hot tier. Writes go to both tiers: with write-through, the cold tier is written as each
+// request is handled, and with write-behind, writes are held and sent to the cold tier every flush interval. Entries
+// of the hot tier which are not set or fetched for a while are demoted, leaving them in the cold tier only.
+//
+// Fetches are served by the hot tier without holding up other requests, and only misses are served by the main loop.
+// Entries are promoted with the Data of the cold tier, keeping their version and expiry time. Entries set with a TTL
+// expire in both tiers.
+//
+// Listing and scanning requests, and TypeIncrement and TypeDecrement requests, are served by the cold tier once any
+// held writes are flushed. Requests which cannot be split across the tiers, such as TypeTransaction, TypeWatch and
+// TypeSnapshot, are rejected with ErrInvalidRequest.
+type TieredModule struct {
+	// App is a pointer to the application context. This stores the channel to the storage subsystem
+	App *coop.ApplicationContext
+
+	// Log is a logger that has been configured for this module to use. Normally, this means it has been set up with
+	// fields that are appropriate to identify this coordinator
+	Log *zap.Logger
+
+	name           string
+	class          string
+	writeMode      string
+	flushInterval  time.Duration
+	demoteAfter    time.Duration
+	demoteInterval time.Duration
+	queueDepth     int
+
+	requestChannel chan *storage.Request
+	mainRunning    sync.WaitGroup
+	hot            *inmemory.InMemoryModule
+	cold           *disk.DiskModule
+
+	// Only used by the main loop
+	pending map[entryKey]pendingWrite
+
+	// fetching counts the fetches waiting for the hot tier, which send their request back over misses on a miss.
+	fetching sync.WaitGroup
+	misses   chan *storage.Request
+
+	// entries holds the entries of the hot tier, tracked as they are set and kept up to date from its journal.
+	entriesLock sync.Mutex
+	entries     map[entryKey]entryState
+
+	statsLock sync.Mutex
+	stats     map[string]*Stats
+
+	quitChannel chan struct{}
+	running     *sync.WaitGroup
+}
+
+// Stats are the counters of a TieredModule for an Index.
+type Stats struct {
+	// Hits is the number of TypeFetchEntry requests served by the hot tier.
+	Hits uint64 `json:"hits"`
+
+	// Misses is the number of TypeFetchEntry requests served by the cold tier.
+	Misses uint64 `json:"misses"`
+
+	// Demoted is the number of entries demoted from the hot tier for not being set or fetched for demote-after.
+	Demoted uint64 `json:"demoted"`
+}
+
+// AssignApplicationContext assigns the underlying ApplicationContext.
+func (module *TieredModule) AssignApplicationContext(app *coop.ApplicationContext) {
+	module.App = app
+}
+
+// ModuleDetails returns the Module class and name.
+func (module *TieredModule) ModuleDetails() (string, string) {
+	return moduleClass, moduleName
+}
+
+// AssignModuleLogger assigns the underlying ApplicationContext.
+func (module *TieredModule) AssignModuleLogger(logger *zap.Logger) {
+	module.Log = logger
+}
+
+// ModuleLogger returns the Modules' underlying Logger.
+func (module *TieredModule) ModuleLogger() *zap.Logger {
+	return module.Log
+}
+
+// Init initializes the Module by setting the name, class and
+// assigning the passed in channel and waitgroup.
+func (module *TieredModule) Init(quitChannel chan struct{}, running *sync.WaitGroup) {
+	module.name = moduleName
+	module.class = moduleClass
+	module.quitChannel = quitChannel
+	module.running = running
+}
+
+// Configure validates the configuration for the module, creates a channel to receive requests on, and configures
+// the hot tier from modules.inmemory and the cold tier from modules.disk. The memory limits of the hot tier, such as
+// max-entries, also demote entries when they are reached.
+//
+// The write-mode is either through, the default, or behind, in which case writes are sent to the cold tier every
+// flush-interval seconds, 1 by default. Entries not set or fetched for demote-after seconds are demoted, checked
+// every demote-interval seconds, 60 by default. A demote-after of 0, the default, disables demotion.
+func (module *TieredModule) Configure() {
+	module.Log.Info("configuring tiered module")
+	configRoot := `modules.tiered`
+
+	viper.SetDefault(configRoot+".write-mode", WriteThrough)
+	viper.SetDefault(configRoot+".flush-interval", 1)
+	viper.SetDefault(configRoot+".demote-after", 0)
+	viper.SetDefault(configRoot+".demote-interval", 60)
+	viper.SetDefault(configRoot+".queue-depth", 1)
+	module.writeMode = viper.GetString(configRoot + ".write-mode")
+	module.flushInterval = time.Duration(viper.GetInt(configRoot+".flush-interval")) * time.Second
+	module.demoteAfter = time.Duration(viper.GetInt(configRoot+".demote-after")) * time.Second
+	module.demoteInterval = time.Duration(viper.GetInt(configRoot+".demote-interval")) * time.Second
+	module.queueDepth = viper.GetInt(configRoot + ".queue-depth")
+
+	if module.writeMode != WriteThrough && module.writeMode != WriteBehind {
+		panic("tiered module write-mode must be either through or behind")
+	}
+	if module.flushInterval < time.Second {
+		panic("tiered module flush-interval must be at least 1 second")
+	}
+	if module.demoteAfter < 0 {
+		panic("tiered module demote-after must not be negative")
+	}
+	if module.demoteInterval < time.Second {
+		panic("tiered module demote-interval must be at least 1 second")
+	}
+
+	module.requestChannel = make(chan *storage.Request, module.queueDepth)
+	module.mainRunning = sync.WaitGroup{}
+	module.pending = make(map[entryKey]pendingWrite)
+	module.misses = make(chan *storage.Request)
+	module.entries = make(map[entryKey]entryState)
+	module.stats = make(map[string]*Stats)
+
+	module.hot = &inmemory.InMemoryModule{}
+	module.hot.Init(module.quitChannel, &sync.WaitGroup{})
+	module.hot.AssignApplicationContext(module.App)
+	module.hot.AssignModuleLogger(module.Log.With(zap.String("tier", "hot")))
+	module.hot.Configure()
+	module.hot.SetJournal(module.track)
+
+	module.cold = &disk.DiskModule{}
+	module.cold.Init(module.quitChannel, &sync.WaitGroup{})
+	module.cold.AssignApplicationContext(module.App)
+	module.cold.AssignModuleLogger(module.Log.With(zap.String("tier", "cold")))
+	module.cold.Configure()
+}
+
+// Start starts the cold tier, which restores the data set from disk, and the hot tier, which starts empty. It then
+// starts the main loop which services requests.
+func (module *TieredModule) Start() error {
+	module.Log.Info("starting",
+		zap.String("write_mode", module.writeMode),
+	)
+	if err := module.cold.Start(); err != nil {
+		return err
+	}
+	if err := module.hot.Start(); err != nil {
+		module.cold.Stop()
+		return err
+	}
+
+	module.mainRunning.Add(1)
+	go module.mainLoop()
+	return nil
+}
+
+// Stop closes the incoming request channel, which will close the main loop once any held writes are flushed, then
+// stops the hot tier and the cold tier.
+func (module *TieredModule) Stop() error {
+	module.Log.Info("stopping")
+
+	close(module.requestChannel)
+	module.mainRunning.Wait()
+
+	hotErr := module.hot.Stop()
+	if err := module.cold.Stop(); err != nil {
+		return err
+	}
+	return hotErr
+}
+
+func (module *TieredModule) mainLoop() {
+	defer module.mainRunning.Done()
+
+	// Using a map for the request types avoids a bit of complexity below
+	var requestTypeMap = map[storage.RequestConstant]func(*storage.Request){
+		storage.TypeSetIndex:       module.addIndex,
+		storage.TypeSetEntry:       module.addEntry,
+		storage.TypeDeleteEntry:    module.deleteEntry,
+		storage.TypeFetchEntry:     module.fetchEntry,
+		storage.TypeFetchIndexes:   module.fetchCold,
+		storage.TypeFetchDatabases: module.fetchCold,
+		storage.TypeFetchEntries:   module.fetchCold,
+		storage.TypeScan:           module.fetchCold,
+		storage.TypeDeleteDB:       module.deleteDB,
+		storage.TypeDeleteIndex:    module.deleteDB,
+		storage.TypeIncrement:      module.increment,
+		storage.TypeDecrement:      module.increment,
+	}
+
+	var flushTicks, demoteTicks <-chan time.Time
+	if module.writeMode == WriteBehind {
+		flushTicker := time.NewTicker(module.flushInterval)
+		defer flushTicker.Stop()
+		flushTicks = flushTicker.C
+	}
+	if module.demoteAfter > 0 {
+		demoteTicker := time.NewTicker(module.demoteInterval)
+		defer demoteTicker.Stop()
+		demoteTicks = demoteTicker.C
+	}
+
+	for {
+		select {
+		case r, ok := <-module.requestChannel:
+			if !ok {
+				module.drain()
+				module.flush()
+				return
+			}
+			if err := r.Context().Err(); err != nil && r.Reply != nil {
+				// Requests without a Reply are writes, which are applied once sent as the sender cannot tell otherwise
+				module.Log.Debug("Skipping Cancelled Request",
+					zap.String("request", r.RequestType.String()),
+					zap.Error(err),
+				)
+				close(r.Reply)
+				continue
+			}
+			requestFunc, ok := requestTypeMap[r.RequestType]
+			if !ok {
+				err := storage.Errorf(storage.CodeInvalidRequest, "%v is not supported by the tiered module", r.RequestType)
+				storage.Reject(r, err)
+				continue
+			}
+			requestFunc(r)
+		case r := <-module.misses:
+			module.fetchMiss(r)
+		case <-flushTicks:
+			module.flush()
+		case <-demoteTicks:
+			module.demote(time.Now().Add(-module.demoteAfter).UnixNano())
+		}
+	}
+}
+
+// Stats returns the counters of the module by Index.
+func (module *TieredModule) Stats() map[string]Stats {
+	module.statsLock.Lock()
+	defer module.statsLock.Unlock()
+	stats := make(map[string]Stats, len(module.stats))
+	for index, s := range module.stats {
+		stats[index] = *s
+	}
+	return stats
+}
+
+// count updates the counters of the Index.
+func (module *TieredModule) count(index string, update func(*Stats)) {
+	module.statsLock.Lock()
+	defer module.statsLock.Unlock()
+	s, ok := module.stats[index]
+	if !ok {
+		s = &Stats{}
+		module.stats[index] = s
+	}
+	update(s)
+}
+
+// SetLimiter sets the Limiter enforcing the memory limits of the hot tier, such as the Limiter of another module, so
+// the limits apply to the entries of both. It must be set after the module is configured and before it is started.
+func (module *TieredModule) SetLimiter(l *inmemory.Limiter) {
+	module.hot.SetLimiter(l)
+}
+
+// GetCommunicationChannel returns the RequestChannel that has been setup for this module.
+func (module *TieredModule) GetCommunicationChannel() chan *storage.Request {
+	return module.requestChannel
+}
@@ -0,0 +1,312 @@
+package tiered
+
+import (
+	"time"
+
+	"github.com/jbvmio/modules/storage"
+)
+
+// entryKey identifies an Entry across all Indexes and DBs.
+type entryKey struct {
+	index string
+	db    string
+	entry string
+}
+
+func keyOf(r *storage.Request) entryKey {
+	return entryKey{index: r.Index, db: r.DB, entry: r.Entry}
+}
+
+// entryState is kept for every Entry of the hot tier, from the changes recorded in its journal.
+type entryState struct {
+	// accessed is the time, in Unix nanoseconds, the Entry was last set or fetched.
+	accessed int64
+}
+
+// pendingWrite is a write held until the next flush with write-behind.
+type pendingWrite struct {
+	request *storage.Request
+
+	// held is the time the write was held, from which the TTL of the Entry counts.
+	held time.Time
+}
+
+// call sends a copy of the request to a tier and returns its reply, or nil if the tier closes the Reply channel
+// without one.
+func call(tier chan *storage.Request, r *storage.Request) interface{} {
+	forward := *r
+	forward.Reply = make(chan interface{}, 1)
+	tier <- &forward
+	var reply interface{}
+	for v := range forward.Reply {
+		reply = v
+	}
+	return reply
+}
+
+// send sends a copy of the request, which has no reply, to a tier.
+func send(tier chan *storage.Request, r *storage.Request) {
+	forward := *r
+	forward.Reply = nil
+	tier <- &forward
+}
+
+// fetch fetches the Entry from a tier.
+func fetch(tier chan *storage.Request, key entryKey) (*storage.Data, interface{}) {
+	reply := call(tier, &storage.Request{
+		RequestType: storage.TypeFetchEntry,
+		Index:       key.index,
+		DB:          key.db,
+		Entry:       key.entry,
+	})
+	data, _ := reply.(*storage.Data)
+	return data, reply
+}
+
+// write sends a TypeSetEntry or TypeDeleteEntry request to the cold tier, or holds it until the next flush with
+// write-behind. Only the last write of an Entry is held.
+func (module *TieredModule) write(r *storage.Request) {
+	if module.writeMode == WriteBehind {
+		module.pending[keyOf(r)] = pendingWrite{request: r, held: time.Now()}
+		return
+	}
+	module.persist(r, time.Now())
+}
+
+// persist sends a TypeSetEntry or TypeDeleteEntry request to the cold tier. The TTL of an Entry held by write-behind
+// counts from the time it was held, so an Entry which has already expired is deleted instead.
+func (module *TieredModule) persist(r *storage.Request, held time.Time) {
+	if r.RequestType == storage.TypeSetEntry && r.TTL > 0 {
+		forward := *r
+		forward.TTL -= time.Since(held)
+		r = &forward
+		if r.TTL <= 0 {
+			r = &storage.Request{
+				RequestType: storage.TypeDeleteEntry,
+				Index:       forward.Index,
+				DB:          forward.DB,
+				Entry:       forward.Entry,
+			}
+		}
+	}
+	if r.RequestType == storage.TypeDeleteEntry {
+		// The cold tier logs an error deleting an Entry it does not have, which is expected for entries which were
+		// never flushed or have expired.
+		if data, _ := fetch(module.cold.GetCommunicationChannel(), keyOf(r)); data == nil {
+			return
+		}
+	}
+	send(module.cold.GetCommunicationChannel(), r)
+}
+
+// flush sends every held write to the cold tier.
+func (module *TieredModule) flush() {
+	for key, w := range module.pending {
+		module.persist(w.request, w.held)
+		delete(module.pending, key)
+	}
+}
+
+// flushEntry sends the held write of the Entry, if any, to the cold tier.
+func (module *TieredModule) flushEntry(key entryKey) {
+	if w, ok := module.pending[key]; ok {
+		module.persist(w.request, w.held)
+		delete(module.pending, key)
+	}
+}
+
+// track keeps the state of the entries of the hot tier up to date from the changes recorded in its journal, so only
+// the entries held by the hot tier are kept, including after they are evicted or expire.
+func (module *TieredModule) track(events ...*storage.Event) {
+	now := time.Now().UnixNano()
+	module.entriesLock.Lock()
+	defer module.entriesLock.Unlock()
+	for _, event := range events {
+		key := entryKey{index: event.Index, db: event.DB, entry: event.Entry}
+		switch event.Type {
+		case storage.EventSet:
+			module.entries[key] = entryState{accessed: now}
+		case storage.EventDelete, storage.EventExpire, storage.EventEvict:
+			delete(module.entries, key)
+		case storage.EventDeleteDB, storage.EventDeleteIndex:
+			for key := range module.entries {
+				if key.index == event.Index && (event.Type == storage.EventDeleteIndex || key.db == event.DB) {
+					delete(module.entries, key)
+				}
+			}
+		}
+	}
+}
+
+// touch records a fetch of the Entry from the hot tier.
+func (module *TieredModule) touch(key entryKey) {
+	module.entriesLock.Lock()
+	defer module.entriesLock.Unlock()
+	if _, ok := module.entries[key]; ok {
+		module.entries[key] = entryState{accessed: time.Now().UnixNano()}
+	}
+}
+
+// drop removes the Entry from the hot tier, if it holds it.
+func (module *TieredModule) drop(key entryKey) bool {
+	module.entriesLock.Lock()
+	_, ok := module.entries[key]
+	module.entriesLock.Unlock()
+	if !ok {
+		return false
+	}
+	send(module.hot.GetCommunicationChannel(), &storage.Request{
+		RequestType: storage.TypeDeleteEntry,
+		Index:       key.index,
+		DB:          key.db,
+		Entry:       key.entry,
+	})
+	return true
+}
+
+// demote removes the entries of the hot tier which were last set or fetched before the given time, in Unix
+// nanoseconds. Held writes are flushed first, as the demoted entries are then only kept in the cold tier.
+func (module *TieredModule) demote(before int64) {
+	module.flush()
+	var keys []entryKey
+	module.entriesLock.Lock()
+	for key, state := range module.entries {
+		if state.accessed < before {
+			keys = append(keys, key)
+		}
+	}
+	module.entriesLock.Unlock()
+
+	demoted := make(map[string]uint64)
+	for _, key := range keys {
+		if module.drop(key) {
+			demoted[key.index]++
+		}
+	}
+	for index, n := range demoted {
+		module.count(index, func(s *Stats) { s.Demoted += n })
+	}
+}
+
+func (module *TieredModule) addIndex(request *storage.Request) {
+	send(module.cold.GetCommunicationChannel(), request)
+	send(module.hot.GetCommunicationChannel(), request)
+}
+
+// addEntry sets the Entry in the hot tier and writes it to the cold tier. An Entry set with a TTL expires in both.
+// The Entry is tracked as soon as it is sent, rather than once the hot tier records it, so it is dropped from the hot
+// tier by a delete sent before then.
+func (module *TieredModule) addEntry(request *storage.Request) {
+	send(module.hot.GetCommunicationChannel(), request)
+	module.entriesLock.Lock()
+	module.entries[keyOf(request)] = entryState{accessed: time.Now().UnixNano()}
+	module.entriesLock.Unlock()
+	module.write(request)
+}
+
+func (module *TieredModule) deleteEntry(request *storage.Request) {
+	module.drop(keyOf(request))
+	module.write(request)
+}
+
+// fetchEntry fetches the Entry from the hot tier without waiting for its reply, so the main loop keeps serving
+// requests meanwhile. On a miss, the request is sent back to the main loop to be served by the cold tier.
+func (module *TieredModule) fetchEntry(request *storage.Request) {
+	if request.Snapshot != "" {
+		storage.Reject(request, storage.Errorf(storage.CodeUnknownSnapshot, "%v", request.Snapshot))
+		return
+	}
+	forward := *request
+	forward.Reply = make(chan interface{}, 1)
+	module.hot.GetCommunicationChannel() <- &forward
+
+	module.fetching.Add(1)
+	go func() {
+		defer module.fetching.Done()
+		var reply interface{}
+		for v := range forward.Reply {
+			reply = v
+		}
+		if data, ok := reply.(*storage.Data); ok && data != nil {
+			module.count(request.Index, func(s *Stats) { s.Hits++ })
+			module.touch(keyOf(request))
+			request.Respond(data)
+			close(request.Reply)
+			return
+		}
+		module.misses <- request
+	}()
+}
+
+// fetchMiss serves a TypeFetchEntry request missed by the hot tier from the cold tier, and promotes the Entry to the
+// hot tier with the Data of the cold tier, keeping its version and expiry time.
+func (module *TieredModule) fetchMiss(request *storage.Request) {
+	defer close(request.Reply)
+	module.count(request.Index, func(s *Stats) { s.Misses++ })
+
+	key := keyOf(request)
+	module.flushEntry(key)
+	data, reply := fetch(module.cold.GetCommunicationChannel(), key)
+	if data == nil {
+		if reply != nil {
+			request.Respond(reply)
+		}
+		return
+	}
+	module.hot.Apply(&storage.Event{
+		Type:  storage.EventSet,
+		Index: key.index,
+		DB:    key.db,
+		Entry: key.entry,
+		New:   data,
+	})
+	request.Respond(data)
+}
+
+// drain serves the misses of the fetches still waiting for the hot tier, once the request channel is closed.
+func (module *TieredModule) drain() {
+	done := make(chan struct{})
+	go func() {
+		module.fetching.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case r := <-module.misses:
+			module.fetchMiss(r)
+		case <-done:
+			return
+		}
+	}
+}
+
+// fetchCold serves the request from the cold tier, which holds every Entry once held writes are flushed.
+func (module *TieredModule) fetchCold(request *storage.Request) {
+	if request.Snapshot != "" {
+		storage.Reject(request, storage.Errorf(storage.CodeUnknownSnapshot, "%v", request.Snapshot))
+		return
+	}
+	module.flush()
+	module.cold.GetCommunicationChannel() <- request
+}
+
+// deleteDB services both TypeDeleteDB and TypeDeleteIndex requests.
+func (module *TieredModule) deleteDB(request *storage.Request) {
+	module.flush()
+	if request.RequestType == storage.TypeDeleteIndex {
+		module.statsLock.Lock()
+		delete(module.stats, request.Index)
+		module.statsLock.Unlock()
+	}
+	send(module.cold.GetCommunicationChannel(), request)
+	send(module.hot.GetCommunicationChannel(), request)
+}
+
+// increment services both TypeIncrement and TypeDecrement requests from the cold tier, which holds the current value
+// once held writes are flushed. The Entry is dropped from the hot tier, so the next fetch promotes the new value.
+func (module *TieredModule) increment(request *storage.Request) {
+	key := keyOf(request)
+	module.flushEntry(key)
+	module.drop(key)
+	module.cold.GetCommunicationChannel() <- request
+}